@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/workflows"
+	"github.com/spf13/cobra"
+)
+
+// NewClustersCmd returns the clusters command used to inspect the fleet
+func NewClustersCmd() *cobra.Command {
+	clustersCmd := &cobra.Command{
+		Use:   "clusters",
+		Short: "Inspect the clusters known to the OCM fleet manager",
+	}
+
+	clustersCmd.AddCommand(&cobra.Command{
+		Use:   "sectors",
+		Short: "Print the sectors discovered in the fleet listing and their cluster counts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := RunContext(cmd.Context())
+			defer cancel()
+
+			opts := RunOptions()
+			sectors, err := workflows.NewRunner(opts).ListSectors(ctx)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Sectors discovered in %s environment:\n", opts.Environment)
+			for _, sector := range sectors.Names() {
+				fmt.Printf("  %s: %d clusters\n", sector, sectors[sector])
+			}
+
+			return nil
+		},
+	})
+
+	return clustersCmd
+}
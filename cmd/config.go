@@ -1,32 +1,460 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/assets"
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	"github.com/MrSantamaria/acceptance_test/workflows"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/exp/slog"
 )
 
+// pushTimeout bounds the push of the metrics at the end of a run
+const pushTimeout = 10 * time.Second
+
+// flushTimeout bounds the export of the last spans when the run exits
+const flushTimeout = 10 * time.Second
+
 var (
 	selectors []string
+	sectors   []string
+
+	// recorder and player are set up from the record and replay flags and carried by every run context
+	recorder *replay.Recorder
+	player   *replay.Player
+
+	// toolsDir is the private directory the bundled tools are extracted to, empty when none were
+	toolsDir string
+
+	// stopTracing flushes the spans and stops the tracer provider installed by SetupTracing
+	stopTracing func(ctx context.Context) error
 )
 
+// recordedKeys are the settings saved with a recording, they decide which clusters are selected and how they are judged
+var recordedKeys = []string{
+	"environment", "operator", "imagetag", "selectors", "sectors", "telemeterSearchTime", "livenessMetric",
+	"inconclusivePolicy", "operatorKinds", "managementChecks", "serviceChecks", "minClusters", "minCoverage",
+	"coveragePolicy", "baselineImagetag", "baselineOffset", "baselineTolerance", "baselineSignals", "mode",
+	"canaryMetrics", "canaryMinClusters", "canaryAlpha", "canaryMarginalAlpha", "canaryPassScore", "canaryMarginalScore",
+}
+
 func InitEnv(rootCmd *cobra.Command) {
 	rootCmd.PersistentFlags().String("token", "", "OCM Token")
 	rootCmd.PersistentFlags().String("env", "", "Environment")
+	rootCmd.PersistentFlags().String("ocm-url", "", "override the OCM API URL of the environment, e.g. to use the fake-ocm server")
 	rootCmd.PersistentFlags().String("operator", "", "operatorName")
 	rootCmd.PersistentFlags().StringSliceVar(&selectors, "selectors", nil, "comma-separated list of cluster deployment selectors")
+	rootCmd.PersistentFlags().StringSliceVar(&sectors, "sectors", nil, "optional comma-separated list of allowed Openshift sectors, validated against the fleet listing")
 	rootCmd.PersistentFlags().String("imagetag", "", "Image Tag")
 	rootCmd.PersistentFlags().String("telemeterClientID", "", "TELEMETER_CLIENT_ID")
 	rootCmd.PersistentFlags().String("telemeterSecret", "", "TELEMETER_SECRET")
 	rootCmd.PersistentFlags().String("telemeterSearchTime", "10m", "TELEMETER_SEARCH_TIME")
+	rootCmd.PersistentFlags().String("liveness-metric", "up", "metric queried to confirm a cluster reports to Telemeter before checking the operator")
+	rootCmd.PersistentFlags().String("inconclusive-policy", "block", "whether clusters without telemetry block the promotion: block or allow")
+	rootCmd.PersistentFlags().StringSlice("operator-kinds", []string{"management", "service"}, "kinds of clusters the operator runs on and is checked on: management, service")
+	rootCmd.PersistentFlags().StringSlice("management-checks", []string{"liveness", "csv_succeeded", "csv_abnormal", "baseline"}, "checks run on management clusters")
+	rootCmd.PersistentFlags().StringSlice("service-checks", []string{"liveness", "csv_succeeded", "csv_abnormal", "baseline"}, "checks run on service clusters")
+	rootCmd.PersistentFlags().Int("min-clusters", 1, "minimum number of clusters which must be verified")
+	rootCmd.PersistentFlags().Float64("min-coverage", 0, "minimum percentage of the selected clusters which must be verified")
+	rootCmd.PersistentFlags().String("coverage-policy", "fail", "what to do when the coverage is below the thresholds: fail or warn")
+	rootCmd.PersistentFlags().String("baseline-imagetag", "", "previously promoted image tag to compare health signals against, comparison is skipped when empty")
+	rootCmd.PersistentFlags().String("baseline-offset", "24h", "how far back the baseline window is, the baseline imagetag must have been running then")
+	rootCmd.PersistentFlags().Float64("baseline-tolerance", 0.1, "allowed increase of a health signal over the baseline, as a fraction of the baseline")
+	rootCmd.PersistentFlags().String("baseline-signals", "", "optional JSON file listing the health signals to compare, defaults to abnormal CSVs, restarts and reconcile errors")
+	rootCmd.PersistentFlags().String("mode", "acceptance", "acceptance checks every cluster, canary compares the clusters on the new imagetag against the ones on the old version")
+	rootCmd.PersistentFlags().String("canary-metrics", "", "optional JSON file listing the metrics compared by the canary analysis, defaults to the baseline health signals")
+	rootCmd.PersistentFlags().Int("canary-min-clusters", 3, "minimum clusters on each version for the canary analysis to reach a verdict")
+	rootCmd.PersistentFlags().Float64("canary-alpha", 0.05, "p-value under which a metric getting worse fails the canary analysis")
+	rootCmd.PersistentFlags().Float64("canary-marginal-alpha", 0.1, "p-value under which a metric getting worse is marginal")
+	rootCmd.PersistentFlags().Float64("canary-pass-score", 95, "minimum canary score, out of 100, to pass")
+	rootCmd.PersistentFlags().Float64("canary-marginal-score", 75, "minimum canary score, out of 100, to be marginal instead of failing")
+	rootCmd.PersistentFlags().Duration("timeout", 30*time.Minute, "timeout for the whole run, 0 disables it")
+	rootCmd.PersistentFlags().Duration("operation-timeout", 2*time.Minute, "timeout for each ocm and obsctl call, 0 disables it")
+	rootCmd.PersistentFlags().Int("retry-max-attempts", 3, "maximum attempts for ocm and telemeter calls failing with a transient error")
+	rootCmd.PersistentFlags().Duration("retry-initial-backoff", 2*time.Second, "backoff before the first retry, doubled on every attempt")
+	rootCmd.PersistentFlags().Duration("retry-max-backoff", 30*time.Second, "maximum backoff between retries")
+	rootCmd.PersistentFlags().Float64("retry-jitter", 0.2, "fraction of the backoff randomly added or removed, between 0 and 1")
+	rootCmd.PersistentFlags().String("report", "", "path of the JSON run report, not written when empty")
+	rootCmd.PersistentFlags().String("markdown-report", "", "path of the Markdown summary of the run, e.g. $GITHUB_STEP_SUMMARY, not written when empty")
+	rootCmd.PersistentFlags().String("html-report", "", "path of the self-contained HTML report plotting the samples of every query, not written when empty")
+	rootCmd.PersistentFlags().String("query-url-template", "", "Go template of the link to a query in the Markdown summary, e.g. https://grafana.example.com/explore?expr={{urlquery .Query}}")
+	rootCmd.PersistentFlags().String("dashboard-url-template", "", "Go template of the link to the dashboard of a cluster in the Markdown summary, e.g. https://grafana.example.com/d/operator?var-cluster={{.ExternalID}}")
+	rootCmd.PersistentFlags().String("webhook-url", "", "URL of a webhook the run start, first failure and completion are posted to as JSON, NOTIFY_WEBHOOK_URL by default")
+	rootCmd.PersistentFlags().String("slack-webhook-url", "", "URL of a Slack incoming webhook the run start, first failure and completion are posted to, SLACK_WEBHOOK_URL by default")
+	rootCmd.PersistentFlags().String("notify-started-template", "", "Go template of the message posted when the run starts, e.g. {{.Operator}}:{{.ImageTag}} started")
+	rootCmd.PersistentFlags().String("notify-failed-template", "", "Go template of the message posted on the first failure of the run, e.g. {{.Failure.ClusterID}}: {{.Failure.Reason}}")
+	rootCmd.PersistentFlags().String("notify-completed-template", "", "Go template of the message posted when the run completes, e.g. {{.Verdict}}{{range .FailingClusters}} {{.ClusterID}}{{end}}")
+	rootCmd.PersistentFlags().String("pushgateway-url", "", "URL of a Pushgateway the metrics of the run are pushed to at its end, PUSHGATEWAY_URL by default")
+	rootCmd.PersistentFlags().String("otlp-endpoint", "", "base URL of an OTLP/HTTP collector the spans of the run are exported to, e.g. http://localhost:4318, OTEL_EXPORTER_OTLP_ENDPOINT by default")
+	rootCmd.PersistentFlags().String("trace-file", "", "file the spans of the run are appended to as JSON, one span per line, not written when empty")
+	rootCmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "log format: text or json")
+	rootCmd.PersistentFlags().String("record", "", "directory where every OCM and Telemeter response of the run is recorded, with secrets scrubbed")
+	rootCmd.PersistentFlags().String("replay", "", "directory of a recorded run to reproduce offline, its configuration is used unless overridden by flags")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "print the clusters and the queries the run would check, from the inventory file or the cached inventory, then exit")
+	rootCmd.PersistentFlags().String("inventory", "", "fleet inventory file a dry run selects the clusters from, the cached inventory is used when empty")
+	rootCmd.PersistentFlags().String("inventory-cache", defaultInventoryCache(), "directory where every run caches the fleet inventory, caching is disabled when empty")
+	rootCmd.PersistentFlags().String("history", defaultHistory(), "file where the report of every run is stored for the history command, runs are not stored when empty")
+	rootCmd.PersistentFlags().Bool("bundled-tools", false, "use the ocm and obsctl binaries bundled with -tags bundled_tools when they are not on the PATH")
 
 	viper.BindPFlag("token", rootCmd.PersistentFlags().Lookup("token"))
 	viper.BindPFlag("environment", rootCmd.PersistentFlags().Lookup("env"))
+	viper.BindPFlag("ocmUrl", rootCmd.PersistentFlags().Lookup("ocm-url"))
 	viper.BindPFlag("operator", rootCmd.PersistentFlags().Lookup("operator"))
 	viper.BindPFlag("selectors", rootCmd.PersistentFlags().Lookup("selectors"))
+	viper.BindPFlag("sectors", rootCmd.PersistentFlags().Lookup("sectors"))
 	viper.BindPFlag("imagetag", rootCmd.PersistentFlags().Lookup("imagetag"))
 	viper.BindPFlag("telemeterClientID", rootCmd.PersistentFlags().Lookup("telemeterClientID"))
 	viper.BindPFlag("telemeterSecret", rootCmd.PersistentFlags().Lookup("telemeterSecret"))
 	viper.BindPFlag("telemeterSearchTime", rootCmd.PersistentFlags().Lookup("telemeterSearchTime"))
+	viper.BindPFlag("livenessMetric", rootCmd.PersistentFlags().Lookup("liveness-metric"))
+	viper.BindPFlag("inconclusivePolicy", rootCmd.PersistentFlags().Lookup("inconclusive-policy"))
+	viper.BindPFlag("operatorKinds", rootCmd.PersistentFlags().Lookup("operator-kinds"))
+	viper.BindPFlag("managementChecks", rootCmd.PersistentFlags().Lookup("management-checks"))
+	viper.BindPFlag("serviceChecks", rootCmd.PersistentFlags().Lookup("service-checks"))
+	viper.BindPFlag("minClusters", rootCmd.PersistentFlags().Lookup("min-clusters"))
+	viper.BindPFlag("minCoverage", rootCmd.PersistentFlags().Lookup("min-coverage"))
+	viper.BindPFlag("coveragePolicy", rootCmd.PersistentFlags().Lookup("coverage-policy"))
+	viper.BindPFlag("baselineImagetag", rootCmd.PersistentFlags().Lookup("baseline-imagetag"))
+	viper.BindPFlag("baselineOffset", rootCmd.PersistentFlags().Lookup("baseline-offset"))
+	viper.BindPFlag("baselineTolerance", rootCmd.PersistentFlags().Lookup("baseline-tolerance"))
+	viper.BindPFlag("baselineSignals", rootCmd.PersistentFlags().Lookup("baseline-signals"))
+	viper.BindPFlag("mode", rootCmd.PersistentFlags().Lookup("mode"))
+	viper.BindPFlag("canaryMetrics", rootCmd.PersistentFlags().Lookup("canary-metrics"))
+	viper.BindPFlag("canaryMinClusters", rootCmd.PersistentFlags().Lookup("canary-min-clusters"))
+	viper.BindPFlag("canaryAlpha", rootCmd.PersistentFlags().Lookup("canary-alpha"))
+	viper.BindPFlag("canaryMarginalAlpha", rootCmd.PersistentFlags().Lookup("canary-marginal-alpha"))
+	viper.BindPFlag("canaryPassScore", rootCmd.PersistentFlags().Lookup("canary-pass-score"))
+	viper.BindPFlag("canaryMarginalScore", rootCmd.PersistentFlags().Lookup("canary-marginal-score"))
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	viper.BindPFlag("operationTimeout", rootCmd.PersistentFlags().Lookup("operation-timeout"))
+	viper.BindPFlag("retryMaxAttempts", rootCmd.PersistentFlags().Lookup("retry-max-attempts"))
+	viper.BindPFlag("retryInitialBackoff", rootCmd.PersistentFlags().Lookup("retry-initial-backoff"))
+	viper.BindPFlag("retryMaxBackoff", rootCmd.PersistentFlags().Lookup("retry-max-backoff"))
+	viper.BindPFlag("retryJitter", rootCmd.PersistentFlags().Lookup("retry-jitter"))
+	viper.BindPFlag("report", rootCmd.PersistentFlags().Lookup("report"))
+	viper.BindPFlag("markdownReport", rootCmd.PersistentFlags().Lookup("markdown-report"))
+	viper.BindPFlag("htmlReport", rootCmd.PersistentFlags().Lookup("html-report"))
+	viper.BindPFlag("queryUrlTemplate", rootCmd.PersistentFlags().Lookup("query-url-template"))
+	viper.BindPFlag("dashboardUrlTemplate", rootCmd.PersistentFlags().Lookup("dashboard-url-template"))
+	viper.BindPFlag("webhookUrl", rootCmd.PersistentFlags().Lookup("webhook-url"))
+	viper.BindPFlag("slackWebhookUrl", rootCmd.PersistentFlags().Lookup("slack-webhook-url"))
+	viper.BindPFlag("notifyStartedTemplate", rootCmd.PersistentFlags().Lookup("notify-started-template"))
+	viper.BindPFlag("notifyFailedTemplate", rootCmd.PersistentFlags().Lookup("notify-failed-template"))
+	viper.BindPFlag("notifyCompletedTemplate", rootCmd.PersistentFlags().Lookup("notify-completed-template"))
+	viper.BindPFlag("pushgatewayUrl", rootCmd.PersistentFlags().Lookup("pushgateway-url"))
+	viper.BindPFlag("otlpEndpoint", rootCmd.PersistentFlags().Lookup("otlp-endpoint"))
+	viper.BindPFlag("traceFile", rootCmd.PersistentFlags().Lookup("trace-file"))
+	viper.BindPFlag("logLevel", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("logFormat", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("record", rootCmd.PersistentFlags().Lookup("record"))
+	viper.BindPFlag("replay", rootCmd.PersistentFlags().Lookup("replay"))
+	viper.BindPFlag("dryRun", rootCmd.PersistentFlags().Lookup("dry-run"))
+	viper.BindPFlag("inventory", rootCmd.PersistentFlags().Lookup("inventory"))
+	viper.BindPFlag("inventoryCache", rootCmd.PersistentFlags().Lookup("inventory-cache"))
+	viper.BindPFlag("history", rootCmd.PersistentFlags().Lookup("history"))
+	viper.BindPFlag("bundledTools", rootCmd.PersistentFlags().Lookup("bundled-tools"))
 
 	viper.AutomaticEnv()
 }
+
+// defaultInventoryCache returns the directory of the user cache where the fleet inventory is cached, empty when there is none
+func defaultInventoryCache() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(cacheDir, "acceptance_test")
+}
+
+// defaultHistory returns the file of the user cache where the runs are stored, empty when there is none
+func defaultHistory() string {
+	cacheDir := defaultInventoryCache()
+	if cacheDir == "" {
+		return ""
+	}
+
+	return filepath.Join(cacheDir, "history.db")
+}
+
+// SetupLogging configures the default logger from the log-level and log-format flags
+func SetupLogging() error {
+	err := logging.Setup(viper.GetString("logLevel"), viper.GetString("logFormat"))
+	if err != nil {
+		return err
+	}
+
+	if environment := viper.GetString("environment"); environment != "" {
+		slog.SetDefault(slog.Default().With("environment", environment))
+	}
+
+	return nil
+}
+
+// SetupTracing exports the spans of the run to the collector and the file of the otlp-endpoint and trace-file flags.
+// The credentials of the run are redacted from the spans.
+func SetupTracing() error {
+	credentials := RunOptions().Credentials
+
+	stop, err := tracing.Setup(tracing.Config{
+		Endpoint: credential("otlpEndpoint", "OTEL_EXPORTER_OTLP_ENDPOINT"),
+		File:     viper.GetString("traceFile"),
+		Secrets:  []string{credentials.OCMToken, credentials.TelemeterClientID, credentials.TelemeterSecret},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	stopTracing = stop
+
+	return nil
+}
+
+// StopTracing exports the spans not exported yet, a failure is only logged as it does not change the outcome of the run
+func StopTracing() {
+	if stopTracing == nil {
+		return
+	}
+
+	// The run context may be done already, e.g. when the run timed out
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	err := stopTracing(ctx)
+	if err != nil {
+		slog.Warn("the spans of the run are not all exported", "error", err)
+	}
+	stopTracing = nil
+}
+
+// SetupRecording prepares the recording or the replay of the run from the record and replay flags.
+// A replay uses the settings of the recorded run as defaults, the flags given on the command line still win.
+func SetupRecording() error {
+	var err error
+
+	recordDir, replayDir := viper.GetString("record"), viper.GetString("replay")
+	if recordDir != "" && replayDir != "" {
+		return fmt.Errorf("--record and --replay cannot be used together")
+	}
+
+	if replayDir != "" {
+		data, err := os.ReadFile(filepath.Join(replayDir, replay.RunFile))
+		if err != nil {
+			return fmt.Errorf("failed to read the recorded run: %w", err)
+		}
+
+		var run map[string]interface{}
+		err = json.Unmarshal(data, &run)
+		if err != nil {
+			return fmt.Errorf("failed to parse the recorded run: %v", err)
+		}
+		for key, value := range run {
+			viper.SetDefault(key, value)
+		}
+
+		player, err = replay.NewPlayer(replayDir)
+		return err
+	}
+
+	if recordDir != "" {
+		recorder, err = replay.NewRecorder(recordDir, viper.GetString("token"), credential("telemeterSecret", "TELEMETER_SECRET"))
+		if err != nil {
+			return err
+		}
+
+		run := map[string]interface{}{}
+		for _, key := range recordedKeys {
+			run[key] = viper.Get(key)
+		}
+
+		return recorder.WriteRun(run)
+	}
+
+	return nil
+}
+
+// SetupTools extracts the bundled ocm and obsctl binaries missing from the PATH when the bundled-tools flag is set
+func SetupTools(ctx context.Context) error {
+	var err error
+
+	if !viper.GetBool("bundledTools") {
+		return nil
+	}
+
+	toolsDir, err = helpers.InstallBundledTools(ctx, assets.Tools)
+	if err != nil {
+		return fmt.Errorf("failed to install the bundled tools: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveTools removes the bundled binaries extracted by SetupTools
+func RemoveTools() {
+	if toolsDir == "" {
+		return
+	}
+
+	err := os.RemoveAll(toolsDir)
+	if err != nil {
+		slog.Warn("failed to remove the bundled tools", "path", toolsDir, "error", err)
+	}
+}
+
+// ReportLinks returns the links of the reports from the URL template flags
+func ReportLinks() (*report.Links, error) {
+	return report.NewLinks(viper.GetString("queryUrlTemplate"), viper.GetString("dashboardUrlTemplate"))
+}
+
+// Notifier returns the notifier of the run from the webhook flags, nil when no webhook is set.
+// The credentials of the run and the webhook URLs themselves are redacted from the notifications.
+func Notifier() (*notify.Notifier, error) {
+	var sinks []notify.Sink
+
+	webhookURL := credential("webhookUrl", "NOTIFY_WEBHOOK_URL")
+	if webhookURL != "" {
+		sinks = append(sinks, notify.NewWebhookSink(webhookURL))
+	}
+	slackWebhookURL := credential("slackWebhookUrl", "SLACK_WEBHOOK_URL")
+	if slackWebhookURL != "" {
+		sinks = append(sinks, notify.NewSlackSink(slackWebhookURL))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	templates := notify.Templates{
+		Started:   viper.GetString("notifyStartedTemplate"),
+		Failed:    viper.GetString("notifyFailedTemplate"),
+		Completed: viper.GetString("notifyCompletedTemplate"),
+	}
+	credentials := RunOptions().Credentials
+
+	return notify.New(sinks, templates, credentials.OCMToken, credentials.TelemeterClientID, credentials.TelemeterSecret, webhookURL, slackWebhookURL)
+}
+
+// PushMetrics pushes the metrics of the run to the Pushgateway, grouped by operator and environment as env,
+// when one is configured. A failure is only logged as it does not change the outcome of the run.
+func PushMetrics(runMetrics *metrics.Metrics, runReport *report.Report) {
+	url := credential("pushgatewayUrl", "PUSHGATEWAY_URL")
+	if url == "" {
+		return
+	}
+
+	// The run context may be done already, e.g. when the run timed out
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	err := runMetrics.Push(ctx, url, "operator", runReport.Operator, "env", runReport.Environment)
+	if err != nil {
+		slog.Warn("the metrics of the run are not pushed", "error", err)
+	}
+}
+
+// RunOptions returns the options of the run from the flags, the environment and the recorded run being replayed
+func RunOptions() workflows.Options {
+	return workflows.Options{
+		Operator:     viper.GetString("operator"),
+		ImageTag:     viper.GetString("imagetag"),
+		Environment:  viper.GetString("environment"),
+		Selectors:    viper.GetStringSlice("selectors"),
+		Sectors:      viper.GetStringSlice("sectors"),
+		SearchWindow: viper.GetString("telemeterSearchTime"),
+		Credentials: workflows.Credentials{
+			OCMToken:          viper.GetString("token"),
+			TelemeterClientID: credential("telemeterClientID", "TELEMETER_CLIENT_ID"),
+			TelemeterSecret:   credential("telemeterSecret", "TELEMETER_SECRET"),
+		},
+		OCMURL:             viper.GetString("ocmUrl"),
+		Inventory:          viper.GetString("inventory"),
+		InventoryCache:     viper.GetString("inventoryCache"),
+		Samples:            viper.GetString("htmlReport") != "",
+		LivenessMetric:     viper.GetString("livenessMetric"),
+		InconclusivePolicy: viper.GetString("inconclusivePolicy"),
+		OperatorKinds:      viper.GetStringSlice("operatorKinds"),
+		ManagementChecks:   viper.GetStringSlice("managementChecks"),
+		ServiceChecks:      viper.GetStringSlice("serviceChecks"),
+		MinClusters:        viper.GetInt("minClusters"),
+		MinCoverage:        viper.GetFloat64("minCoverage"),
+		CoveragePolicy:     viper.GetString("coveragePolicy"),
+		Mode:               viper.GetString("mode"),
+		Baseline: workflows.BaselineOptions{
+			ImageTag:    viper.GetString("baselineImagetag"),
+			Offset:      viper.GetString("baselineOffset"),
+			Tolerance:   viper.GetFloat64("baselineTolerance"),
+			SignalsFile: viper.GetString("baselineSignals"),
+		},
+		Canary: workflows.CanaryOptions{
+			MetricsFile:   viper.GetString("canaryMetrics"),
+			MinClusters:   viper.GetInt("canaryMinClusters"),
+			Alpha:         viper.GetFloat64("canaryAlpha"),
+			MarginalAlpha: viper.GetFloat64("canaryMarginalAlpha"),
+			PassScore:     viper.GetFloat64("canaryPassScore"),
+			MarginalScore: viper.GetFloat64("canaryMarginalScore"),
+		},
+	}
+}
+
+// credential returns the value given by flag, falling back to the env variable
+func credential(flag, env string) string {
+	if value := viper.GetString(flag); value != "" {
+		return value
+	}
+
+	return viper.GetString(env)
+}
+
+// RetryPolicy returns the retry policy of the ocm and obsctl calls from the retry flags
+func RetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts:    viper.GetInt("retryMaxAttempts"),
+		InitialBackoff: viper.GetDuration("retryInitialBackoff"),
+		MaxBackoff:     viper.GetDuration("retryMaxBackoff"),
+		Multiplier:     2,
+		Jitter:         viper.GetFloat64("retryJitter"),
+	}
+}
+
+// ValidateRetryPolicy checks the retry flags
+func ValidateRetryPolicy() error {
+	err := RetryPolicy().Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %w", workflows.ErrConfiguration, err)
+	}
+
+	return nil
+}
+
+// RunContext derives the context for a run from parent, applying the run timeout
+// and carrying the per-operation timeout and retry policy used for every ocm and obsctl call,
+// along with the recorder or the player of the run.
+func RunContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := helpers.WithOperationTimeout(parent, viper.GetDuration("operationTimeout"))
+	if recorder != nil {
+		ctx = replay.WithRecorder(ctx, recorder)
+	}
+	if player != nil {
+		ctx = replay.WithPlayer(ctx, player)
+	}
+	ctx = retry.WithPolicy(ctx, RetryPolicy())
+
+	if timeout := viper.GetDuration("timeout"); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/workflows"
+	"github.com/spf13/cobra"
+)
+
+// NewDoctorCmd returns the doctor command which runs the preflight checks of a run without running it
+func NewDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the binaries, credentials, OCM and Observatorium access and selectors of a run without running it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := RunContext(cmd.Context())
+			defer cancel()
+
+			results, err := workflows.NewRunner(RunOptions()).Doctor(ctx)
+			for _, result := range results {
+				fmt.Printf("[%s] %s: %s\n", result.Status, result.Name, result.Detail)
+				if result.Hint != "" {
+					fmt.Printf("       hint: %s\n", result.Hint)
+				}
+			}
+			if err != nil {
+				// The checklist already shows what failed
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return fmt.Errorf("doctor found problems: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
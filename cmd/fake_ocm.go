@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm/ocmfake"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slog"
+)
+
+// NewFakeOCMCmd returns the fake-ocm command which serves a local stand-in of the OCM API for development
+func NewFakeOCMCmd() *cobra.Command {
+	var opts ocmfake.Options
+	var listen string
+
+	fakeOCMCmd := &cobra.Command{
+		Use:   "fake-ocm",
+		Short: "Serve the OCM fleet manager and clusters_mgmt endpoints from fixtures for local development",
+		Long: `fake-ocm serves the OCM endpoints used by the acceptance test from fixtures until it is interrupted.
+Point the acceptance test at it with --ocm-url and the printed token.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handler, err := ocmfake.NewHandler(opts)
+			if err != nil {
+				return err
+			}
+
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", listen, err)
+			}
+
+			server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				<-cmd.Context().Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				server.Shutdown(shutdownCtx)
+			}()
+
+			url := "http://" + listener.Addr().String()
+			slog.Info("fake OCM API listening", "url", url)
+			fmt.Printf("URL:   %s\nToken: %s\n", url, handler.Token())
+
+			err = server.Serve(listener)
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+
+			return err
+		},
+	}
+
+	fakeOCMCmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8000", "address to listen on")
+	fakeOCMCmd.Flags().StringVar(&opts.FixturesDir, "fixtures", "", "directory with management_clusters.json, service_clusters.json and clusters/<id>.json, the built-in fixtures are used when empty")
+	fakeOCMCmd.Flags().StringVar(&opts.Token, "fake-token", "", "bearer token the requests must carry, an unsigned non-expiring token is generated when empty")
+	fakeOCMCmd.Flags().IntVar(&opts.PageSize, "page-size", 100, "default page size of the cluster lists")
+	fakeOCMCmd.Flags().DurationVar(&opts.Latency, "latency", 0, "delay added to every response")
+
+	return fakeOCMCmd
+}
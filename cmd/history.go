@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/history"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// openHistory opens the run history named by the history flag, it returns nil when the history is disabled
+func openHistory() (*history.Store, error) {
+	path := viper.GetString("history")
+	if path == "" {
+		return nil, nil
+	}
+
+	return history.Open(path)
+}
+
+// StoreRun adds the report of a run to the history, a failure is only logged as it does not change the outcome of the run
+func StoreRun(ctx context.Context, runReport *report.Report) {
+	logger := logging.FromContext(ctx)
+
+	store, err := openHistory()
+	if err != nil {
+		logger.Warn("the run is not stored in the history", "error", err)
+		return
+	}
+	if store == nil {
+		return
+	}
+	defer store.Close()
+
+	id, err := store.Add(runReport)
+	if err != nil {
+		logger.Warn("the run is not stored in the history", "error", err)
+		return
+	}
+
+	logger.Info("run stored in the history", "history_id", id)
+}
+
+// NewHistoryCmd returns the history command which lists, shows and compares the stored runs
+func NewHistoryCmd() *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List, show and compare the runs stored in the history",
+		Long: `history reads the runs stored in the file of the history flag.
+list is filtered with the operator, imagetag and env flags of the root command.`,
+	}
+
+	historyCmd.AddCommand(newHistoryListCmd(), newHistoryShowCmd(), newHistoryDiffCmd())
+
+	return historyCmd
+}
+
+func newHistoryListCmd() *cobra.Command {
+	var filter history.Filter
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the stored runs, from the oldest to the most recent",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The arguments are valid, main prints the errors of the history without the usage
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			store, err := openHistoryStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			filter.Operator = viper.GetString("operator")
+			filter.ImageTag = viper.GetString("imagetag")
+			filter.Environment = viper.GetString("environment")
+
+			entries, err := store.List(filter)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				fmt.Println("No runs found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			header := "ID\tSTARTED\tOPERATOR\tIMAGETAG\tENV\tVERDICT"
+			if filter.Cluster != "" {
+				header += "\tCLUSTER"
+			}
+			fmt.Fprintln(w, header)
+			for _, entry := range entries {
+				r := entry.Report
+				line := fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s", entry.ID, r.StartTime.UTC().Format(time.RFC3339), r.Operator, r.ImageTag, r.Environment, r.Verdict)
+				if filter.Cluster != "" {
+					line += "\t" + history.ClusterVerdict(&r, filter.Cluster)
+				}
+				fmt.Fprintln(w, line)
+			}
+			w.Flush()
+
+			if filter.Cluster == "" {
+				return nil
+			}
+
+			// The start of the failures is looked for in every run of the cluster, not only the listed ones
+			all := filter
+			all.Limit = 0
+			entries, err = store.List(all)
+			if err != nil {
+				return err
+			}
+
+			since, failing := history.FailingSince(entries, filter.Cluster)
+			if !failing {
+				fmt.Printf("\n%s passed in its most recent run\n", filter.Cluster)
+				return nil
+			}
+			fmt.Printf("\n%s is failing since run %d, started %s with %s:%s\n", filter.Cluster, since.ID,
+				since.Report.StartTime.UTC().Format(time.RFC3339), since.Report.Operator, since.Report.ImageTag)
+
+			return nil
+		},
+	}
+
+	listCmd.Flags().StringVar(&filter.Cluster, "cluster", "", "only list the runs which checked this cluster, by cluster ID or external ID, and tell since when it is failing")
+	listCmd.Flags().IntVar(&filter.Limit, "limit", 20, "number of most recent runs listed, 0 lists them all")
+
+	return listCmd
+}
+
+func newHistoryShowCmd() *cobra.Command {
+	var asJSON bool
+
+	showCmd := &cobra.Command{
+		Use:   "show <id>",
+		Short: "Print the report of a stored run as a Markdown summary or as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The arguments are valid, main prints the errors of the history without the usage
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			store, err := openHistoryStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			entry, err := getRun(store, args[0])
+			if err != nil {
+				return err
+			}
+
+			if !asJSON {
+				fmt.Print(entry.Report.Markdown(nil))
+				return nil
+			}
+
+			data, err := json.MarshalIndent(entry.Report, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal report: %w", err)
+			}
+			fmt.Println(string(data))
+
+			return nil
+		},
+	}
+
+	showCmd.Flags().BoolVar(&asJSON, "json", false, "print the JSON report of the run")
+
+	return showCmd
+}
+
+func newHistoryDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <id> <id>",
+		Short: "Print the verdicts and the checks which changed between two stored runs",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The arguments are valid, main prints the errors of the history without the usage
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			store, err := openHistoryStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			before, err := getRun(store, args[0])
+			if err != nil {
+				return err
+			}
+			after, err := getRun(store, args[1])
+			if err != nil {
+				return err
+			}
+
+			changes := history.Diff(&before.Report, &after.Report)
+			if len(changes) == 0 {
+				fmt.Printf("No change between run %d and run %d\n", before.ID, after.ID)
+				return nil
+			}
+
+			for _, change := range changes {
+				switch {
+				case change.ClusterID == "":
+					fmt.Printf("run: %s -> %s\n", change.Before, change.After)
+				case change.Check == "":
+					fmt.Printf("%s %s: %s -> %s\n", change.Kind, change.ClusterID, change.Before, change.After)
+				default:
+					fmt.Printf("%s %s %s: %s -> %s\n", change.Kind, change.ClusterID, change.Check, change.Before, change.After)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// openHistoryStore opens the run history read-only for the history command, which has nothing to read when it is disabled.
+// The command does not wait for the runs, which lock the history only while they add their report.
+func openHistoryStore() (*history.Store, error) {
+	path := viper.GetString("history")
+	if path == "" {
+		return nil, fmt.Errorf("%w: the run history is disabled, set --history", workflows.ErrConfiguration)
+	}
+
+	// A history no run was stored in yet is created empty
+	store, err := history.OpenReadOnly(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		store, err = history.Open(path)
+		if err == nil {
+			store.Close()
+			store, err = history.OpenReadOnly(path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", workflows.ErrInfrastructure, err)
+	}
+
+	return store, nil
+}
+
+func getRun(store *history.Store, arg string) (*history.Entry, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid run ID %q", workflows.ErrConfiguration, arg)
+	}
+
+	entry, err := store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", workflows.ErrConfiguration, err)
+	}
+
+	return entry, nil
+}
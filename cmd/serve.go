@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/exp/slog"
+)
+
+// NewServeCmd returns the serve command which runs the acceptance tests submitted over an HTTP API
+func NewServeCmd() *cobra.Command {
+	var config server.Config
+	var listen string
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the acceptance tests submitted over an HTTP API with a bounded pool of workers",
+		Long: `serve runs the acceptance tests submitted with POST /runs until it is interrupted.
+The flags of the root command, including the credentials, are the defaults of every run,
+a run request only sets its operator, imagetag, environment and selectors.
+The requests must carry the bearer token given by --api-token or SERVE_API_TOKEN when it is set.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if viper.GetString("record") != "" || viper.GetString("replay") != "" || viper.GetBool("dryRun") {
+				return fmt.Errorf("--record, --replay and --dry-run cannot be used with serve")
+			}
+
+			// Invalid notification settings are reported once instead of on every run
+			_, err := Notifier()
+			if err != nil {
+				return err
+			}
+
+			if config.Token == "" {
+				config.Token = viper.GetString("SERVE_API_TOKEN")
+			}
+
+			config.Defaults = RunOptions()
+			// The server writes no HTML report, the samples would only bloat the reports it keeps and stores
+			config.Defaults.Samples = false
+			config.RunContext = RunContext
+			config.Notifier = Notifier
+			config.Metrics = metrics.New()
+			config.Logger = func(w io.Writer) *slog.Logger {
+				logger, err := logging.New(io.MultiWriter(os.Stdout, w), viper.GetString("logLevel"), viper.GetString("logFormat"))
+				if err != nil {
+					return slog.Default()
+				}
+				return logger
+			}
+
+			// An unusable history is reported once instead of on every run
+			store, err := openHistory()
+			if err != nil {
+				return err
+			}
+			if store != nil {
+				store.Close()
+				config.History = viper.GetString("history")
+			}
+
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", listen, err)
+			}
+
+			if config.Token == "" && !isLoopback(listener.Addr()) {
+				slog.Warn("the runs are open to anyone who can reach the server, set --api-token", "listen", listen)
+			}
+
+			runs := server.New(config)
+			defer runs.Close()
+
+			httpServer := &http.Server{Handler: runs.Handler(), ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				<-cmd.Context().Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				httpServer.Shutdown(shutdownCtx)
+			}()
+
+			slog.Info("acceptance test server listening", "url", "http://"+listener.Addr().String(),
+				"workers", config.Workers, "queue_size", config.QueueSize)
+
+			err = httpServer.Serve(listener)
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+
+			return err
+		},
+	}
+
+	serveCmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+	serveCmd.Flags().StringVar(&config.Token, "api-token", "", "bearer token the requests to /runs must carry, SERVE_API_TOKEN by default")
+	serveCmd.Flags().IntVar(&config.Workers, "workers", 2, "number of runs executed at once")
+	serveCmd.Flags().IntVar(&config.QueueSize, "queue-size", 10, "number of runs waiting for a worker, further runs are refused")
+	serveCmd.Flags().IntVar(&config.Retain, "retain", 100, "number of finished runs kept in memory with their report and log, 0 keeps them all")
+
+	return serveCmd
+}
+
+// isLoopback returns whether addr only accepts connections from the local host
+func isLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
@@ -0,0 +1,34 @@
+#!/usr/bin/env bash
+# Builds the pinned ocm and obsctl binaries into pkg/assets/tools along with their manifest,
+# for a binary built with `go build -tags bundled_tools`. GOOS and GOARCH select the target platform.
+set -euo pipefail
+
+OCM_VERSION="${OCM_VERSION:-v0.1.72}"
+OBSCTL_VERSION="${OBSCTL_VERSION:?set OBSCTL_VERSION to the obsctl module version to bundle, e.g. a pseudo-version of main}"
+
+root="$(cd "$(dirname "$0")/.." && pwd)"
+out="${root}/pkg/assets/tools"
+work="$(mktemp -d)"
+trap 'rm -rf "${work}"' EXIT
+
+build() {
+	local name="$1" pkg="$2" version="$3"
+
+	# go install keeps the module version in the binary, cross-compiled ones land in bin/<goos>_<goarch>
+	GOPATH="${work}" GOMODCACHE="$(go env GOMODCACHE)" GOBIN= CGO_ENABLED=0 go install -trimpath "${pkg}@${version}"
+	find "${work}/bin" -type f -name "${name}" -exec mv {} "${out}/${name}" \;
+
+	local resolved sum
+	resolved="$(go version -m "${out}/${name}" | awk '$1 == "mod" { print $3; exit }')"
+	sum="$(sha256sum "${out}/${name}" | awk '{ print $1 }')"
+	printf '{"name": "%s", "version": "%s", "sha256": "%s"}' "${name}" "${resolved}" "${sum}"
+}
+
+find "${out}" -mindepth 1 ! -name .gitignore -delete
+
+ocm="$(build ocm github.com/openshift-online/ocm-cli/cmd/ocm "${OCM_VERSION}")"
+obsctl="$(build obsctl github.com/observatorium/obsctl "${OBSCTL_VERSION}")"
+printf '[\n  %s,\n  %s\n]\n' "${ocm}" "${obsctl}" > "${out}/manifest.json"
+
+echo "bundled tools written to ${out}:"
+cat "${out}/manifest.json"
@@ -1,59 +1,147 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/MrSantamaria/acceptance_test/cmd"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
 	"github.com/MrSantamaria/acceptance_test/workflows"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/exp/slog"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "acceptance_test",
 	Short: "acceptance_test is a component of the Hypershift Operator Promotion process",
 	Long:  `acceptance_test is a tool used to validate Hypershift Operator Promotions ocurred successfully`,
-	Run: func(cmd *cobra.Command, args []string) {
-		var errs []error
-		var err error
+	PersistentPreRunE: func(command *cobra.Command, args []string) error {
+		err := cmd.SetupRecording()
+		if err != nil {
+			return err
+		}
 
-		err = workflows.SetUp(viper.GetString("token"), viper.GetString("environment"))
+		err = cmd.SetupLogging()
 		if err != nil {
-			fmt.Println(err)
-			errs = append(errs, err)
+			return err
 		}
 
-		err = workflows.AcceptanceTest()
+		err = cmd.ValidateRetryPolicy()
 		if err != nil {
-			fmt.Println(err)
-			errs = append(errs, err)
+			return err
 		}
 
-		err = workflows.CleanUp()
+		err = cmd.SetupTracing()
 		if err != nil {
-			fmt.Println(err)
-			errs = append(errs, err)
+			return err
+		}
+
+		return cmd.SetupTools(command.Context())
+	},
+	Run: func(command *cobra.Command, args []string) {
+		runner := workflows.NewRunner(cmd.RunOptions())
+
+		if viper.GetBool("dryRun") {
+			plan, err := runner.Plan(command.Context())
+			if err == nil {
+				err = plan.Write(os.Stdout)
+			}
+			if err != nil {
+				slog.Error("dry run failed", "phase", "plan", "error", err)
+				exit(workflows.ExitCode([]error{err}))
+			}
+			return
+		}
+
+		links, err := cmd.ReportLinks()
+		if err != nil {
+			slog.Error("invalid report link template", "error", err)
+			exit(workflows.ExitConfiguration)
+		}
+
+		notifier, err := cmd.Notifier()
+		if err != nil {
+			slog.Error("invalid notification settings", "error", err)
+			exit(workflows.ExitConfiguration)
+		}
+
+		runMetrics := metrics.New()
+		ctx, cancel := cmd.RunContext(metrics.WithMetrics(notify.WithNotifier(command.Context(), notifier), runMetrics))
+		defer cancel()
+
+		runReport, errs := runner.Run(ctx)
+
+		// A replayed run is a copy of a run which was already stored and measured
+		if viper.GetString("replay") == "" {
+			cmd.StoreRun(ctx, runReport)
+			cmd.PushMetrics(runMetrics, runReport)
+		}
+
+		if path := viper.GetString("report"); path != "" {
+			err = runReport.WriteJSON(path)
+			if err != nil {
+				slog.Error("failed to write run report", "path", path, "error", err)
+			}
+		}
+
+		if path := viper.GetString("markdownReport"); path != "" {
+			err = runReport.WriteMarkdown(path, links)
+			if err != nil {
+				slog.Error("failed to write markdown report", "path", path, "error", err)
+			}
+		}
+
+		if path := viper.GetString("htmlReport"); path != "" {
+			err = runReport.WriteHTML(path, links)
+			if err != nil {
+				slog.Error("failed to write HTML report", "path", path, "error", err)
+			}
 		}
 
 		if len(errs) > 0 {
-			fmt.Printf("Acceptance Test FAILED for: %s %s environment: %s selectors: %v\n",
-				viper.GetString("operator"),
-				viper.GetString("imagetag"),
-				viper.GetString("environment"),
-				viper.GetStringSlice("selectors"))
-			os.Exit(1)
+			slog.Error("Acceptance Test "+runReport.Verdict,
+				"verdict", runReport.Verdict,
+				"operator", runReport.Operator,
+				"imagetag", runReport.ImageTag,
+				"selectors", runReport.Selectors,
+				"failed_attempts", len(runReport.Retries))
+			exit(workflows.ExitCode(errs))
 		}
 	},
 }
 
 func main() {
 	cmd.InitEnv(rootCmd)
+	rootCmd.AddCommand(cmd.NewClustersCmd())
+	rootCmd.AddCommand(cmd.NewDoctorCmd())
+	rootCmd.AddCommand(cmd.NewFakeOCMCmd())
+	rootCmd.AddCommand(cmd.NewHistoryCmd())
+	rootCmd.AddCommand(cmd.NewServeCmd())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		if workflows.Classified(err) {
+			exit(workflows.ExitCode([]error{err}))
+		}
+		// Flag parsing and other usage errors reported by cobra
+		exit(workflows.ExitConfiguration)
 	}
 
-	os.Exit(0)
+	exit(workflows.ExitPassed)
+}
+
+// exit removes the bundled tools and exports the last spans before exiting with code, deferred calls do not run
+func exit(code int) {
+	cmd.RemoveTools()
+	cmd.StopTracing()
+	os.Exit(code)
 }
@@ -4,5 +4,5 @@ import (
 	"embed"
 )
 
-//go:embed *
+//go:embed *.json
 var Assets embed.FS
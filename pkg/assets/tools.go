@@ -0,0 +1,12 @@
+//go:build bundled_tools
+
+package assets
+
+import (
+	"embed"
+)
+
+// Tools holds the pinned ocm and obsctl binaries and their manifest, written by hack/bundle-tools.sh
+//
+//go:embed tools
+var Tools embed.FS
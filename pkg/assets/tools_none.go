@@ -0,0 +1,10 @@
+//go:build !bundled_tools
+
+package assets
+
+import (
+	"embed"
+)
+
+// Tools is empty unless the binary is built with -tags bundled_tools
+var Tools embed.FS
@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+)
+
+type operationTimeoutKey struct{}
+type commandEnvKey struct{}
+
+// Redacted replaces the secrets wherever they would leave the process: logs, recordings, notifications and spans
+const Redacted = "REDACTED"
+
+// minSecretLength is the length under which a secret is not scrubbed from the text, short values would mangle unrelated text
+const minSecretLength = 8
+
+// secretFlags are the command line flags whose values must never be logged
+var secretFlags = []string{
+	"--token",
+	"--oidc.client-secret",
+}
+
+// WithOperationTimeout returns a copy of ctx carrying the deadline applied to every command run with it
+func WithOperationTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, operationTimeoutKey{}, timeout)
+}
+
+// OperationTimeout returns the per-operation timeout stored in ctx, zero if none was set
+func OperationTimeout(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(operationTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// WithCommandEnv returns a copy of ctx carrying environment variables, as KEY=value, set for every command run with it
+// on top of the environment of the process
+func WithCommandEnv(ctx context.Context, env ...string) context.Context {
+	return context.WithValue(ctx, commandEnvKey{}, append(CommandEnv(ctx), env...))
+}
+
+// CommandEnv returns the environment variables stored in ctx by WithCommandEnv
+func CommandEnv(ctx context.Context) []string {
+	env, _ := ctx.Value(commandEnvKey{}).([]string)
+	// Capped so that appending to it never writes to the slice of the parent context
+	return env[:len(env):len(env)]
+}
+
+// LookupCommandEnv returns the value of the environment variable key stored in ctx by WithCommandEnv
+func LookupCommandEnv(ctx context.Context, key string) (string, bool) {
+	env := CommandEnv(ctx)
+
+	// The last value wins, as in the environment of the commands
+	for i := len(env) - 1; i >= 0; i-- {
+		if value, ok := strings.CutPrefix(env[i], key+"="); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// RunCommand runs the named binary with args capturing its standard output and error.
+// The bundled binary is run instead when InstallBundledTools extracted one for name.
+// The environment variables stored in ctx override the ones of the process.
+// The command is killed when ctx is done or when the operation timeout stored in ctx expires,
+// in which case the returned error wraps the context error.
+// The invocation is logged at debug level with secret flag values redacted.
+func RunCommand(ctx context.Context, name string, args []string, attrs ...any) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	if timeout := OperationTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, ToolPath(name), args...)
+	if env := CommandEnv(ctx); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logger := logging.FromContext(ctx).With(attrs...)
+	logger.Debug("running command", "args", RedactArgs(cmd.Args))
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("%s %s did not complete: %w", name, strings.Join(RedactArgs(args), " "), ctx.Err())
+	}
+	if err != nil {
+		logger.Debug("command failed", "args", RedactArgs(cmd.Args), "error", err, "stderr", stderr.String())
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// RedactArgs returns a copy of args where the values of secret flags are replaced
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i, arg := range redacted {
+		for _, flag := range secretFlags {
+			if strings.HasPrefix(arg, flag+"=") {
+				redacted[i] = flag + "=" + Redacted
+			}
+			if arg == flag && i+1 < len(redacted) {
+				redacted[i+1] = Redacted
+			}
+		}
+	}
+
+	return redacted
+}
+
+// Scrubber replaces known secrets in free text, e.g. error messages carrying the output of ocm and obsctl.
+// The zero Scrubber leaves the text unchanged.
+type Scrubber struct {
+	secrets []string
+}
+
+// NewScrubber returns a scrubber of the secrets, the empty and short ones are ignored
+func NewScrubber(secrets ...string) Scrubber {
+	var s Scrubber
+
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			s.secrets = append(s.secrets, secret)
+		}
+	}
+
+	return s
+}
+
+// Empty returns whether the scrubber has no secret to replace
+func (s Scrubber) Empty() bool {
+	return len(s.secrets) == 0
+}
+
+// Scrub replaces the secrets in text with Redacted
+func (s Scrubber) Scrub(text string) string {
+	for _, secret := range s.secrets {
+		text = strings.ReplaceAll(text, secret, Redacted)
+	}
+
+	return text
+}
@@ -0,0 +1,45 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{"ocm", "login", "--token=s3cr3t-ocm-token", "--url", "stage"}
+	redacted := RedactArgs(args)
+
+	want := []string{"ocm", "login", "--token=REDACTED", "--url", "stage"}
+	if !reflect.DeepEqual(redacted, want) {
+		t.Errorf("RedactArgs() = %q, want %q", redacted, want)
+	}
+	if args[2] != "--token=s3cr3t-ocm-token" {
+		t.Errorf("RedactArgs() changed its argument: %q", args)
+	}
+
+	redacted = RedactArgs([]string{"obsctl", "context", "api", "add", "--oidc.client-secret", "s3cr3t-client-secret"})
+	if redacted[5] != Redacted {
+		t.Errorf("RedactArgs() = %q, want the client secret redacted", redacted)
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	scrubber := NewScrubber("s3cr3t-ocm-token", "", "short", "s3cr3t-client-secret")
+
+	text := "login with s3cr3t-ocm-token failed: invalid client s3cr3t-client-secret, short token"
+	want := "login with REDACTED failed: invalid client REDACTED, short token"
+	if scrubbed := scrubber.Scrub(text); scrubbed != want {
+		t.Errorf("Scrub() = %q, want %q", scrubbed, want)
+	}
+	if scrubber.Empty() {
+		t.Error("Empty() = true, want the secrets to be kept")
+	}
+
+	var zero Scrubber
+	if !zero.Empty() || zero.Scrub(text) != text {
+		t.Error("the zero Scrubber changed the text, want it unchanged")
+	}
+	if !NewScrubber("short", "").Empty() {
+		t.Error("Empty() = false for short secrets only, want them ignored")
+	}
+}
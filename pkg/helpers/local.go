@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"golang.org/x/exp/slog"
 )
 
 type Config struct {
@@ -19,7 +21,7 @@ func SetEnvVariables(args string) {
 	for _, pair := range pairs {
 		parts := strings.SplitN(pair, ":", 2)
 		if len(parts) != 2 {
-			fmt.Println("Invalid key-value pair:", pair)
+			slog.Warn("Invalid key-value pair, expected key:value", "pair", pair)
 			continue
 		}
 		key := strings.TrimSpace(parts[0])
@@ -27,7 +29,7 @@ func SetEnvVariables(args string) {
 
 		err := os.Setenv(key, value)
 		if err != nil {
-			fmt.Printf("Failed to set environment variable %q: %v\n", key, err)
+			slog.Error("Failed to set environment variable", "key", key, "error", err)
 		}
 	}
 }
@@ -82,8 +84,7 @@ func CheckBinary(binaryPath string) error {
 
 	err := cmd.Run()
 	if err != nil {
-		fmt.Printf("Binary execution error: %v\n", err)
-		fmt.Printf("Standard Error:\n%s\n", stderr.String())
+		slog.Error("Binary execution error", "binary", binaryPath, "error", err, "stderr", stderr.String())
 		return err
 	}
 
@@ -1,11 +1,46 @@
 package helpers
 
-var openshiftSectorMap = map[string]bool{
-	"canary":    true,
-	"ibm-infra": true,
-	"main":      true,
+import "sort"
+
+// SectorSet holds the sectors discovered in the fleet listing, keyed by sector
+// name with the number of clusters that belong to each sector.
+type SectorSet map[string]int
+
+// Add records one cluster for the given sector. Clusters without a sector are ignored.
+func (s SectorSet) Add(sector string) {
+	if sector == "" {
+		return
+	}
+	s[sector]++
+}
+
+// Names returns the discovered sector names in alphabetical order.
+func (s SectorSet) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
 
-func IsOpenshiftSector(sector string) bool {
-	return openshiftSectorMap[sector]
+// IsOpenshiftSector reports whether the sector was discovered in the fleet listing.
+// When allowed is not empty the sector must also be part of that list.
+func IsOpenshiftSector(sector string, discovered SectorSet, allowed []string) bool {
+	if _, ok := discovered[sector]; !ok {
+		return false
+	}
+
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, allowedSector := range allowed {
+		if allowedSector == sector {
+			return true
+		}
+	}
+
+	return false
 }
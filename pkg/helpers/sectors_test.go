@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSectorSet(t *testing.T) {
+	sectors := SectorSet{}
+	for _, sector := range []string{"main", "canary", "", "main"} {
+		sectors.Add(sector)
+	}
+
+	if want := (SectorSet{"main": 2, "canary": 1}); !reflect.DeepEqual(sectors, want) {
+		t.Errorf("sectors = %v, want %v", sectors, want)
+	}
+	if names := sectors.Names(); !reflect.DeepEqual(names, []string{"canary", "main"}) {
+		t.Errorf("Names() = %v, want the sectors in alphabetical order", names)
+	}
+}
+
+func TestIsOpenshiftSector(t *testing.T) {
+	discovered := SectorSet{"main": 2, "canary": 1}
+
+	tests := []struct {
+		sector  string
+		allowed []string
+		want    bool
+	}{
+		{"main", nil, true},
+		{"canary", nil, true},
+		{"unknown", nil, false},
+		{"", nil, false},
+		{"main", []string{"main"}, true},
+		{"canary", []string{"main"}, false},
+		{"unknown", []string{"unknown"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsOpenshiftSector(tt.sector, discovered, tt.allowed); got != tt.want {
+			t.Errorf("IsOpenshiftSector(%q, %v) = %v, want %v", tt.sector, tt.allowed, got, tt.want)
+		}
+	}
+}
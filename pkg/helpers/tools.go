@@ -0,0 +1,162 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// ToolsManifest is the file of the bundled tools listing them with their version and checksum
+const ToolsManifest = "tools/manifest.json"
+
+// ErrNoBundledTools is returned when the binary was built without bundled tools
+var ErrNoBundledTools = errors.New("no tools are bundled in this binary, it must be built with -tags bundled_tools")
+
+// BundledTool is a command line tool embedded in the binary, stored as tools/<name>
+type BundledTool struct {
+	Name string `json:"name"`
+	// Version is the module version of the tool, as reported by go version -m
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+var (
+	toolsMu   sync.RWMutex
+	toolPaths = map[string]string{}
+)
+
+// ToolPath returns the path RunCommand uses for the named tool, the extracted bundled binary when one was installed
+func ToolPath(name string) string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+
+	if path, ok := toolPaths[name]; ok {
+		return path
+	}
+
+	return name
+}
+
+// ToolVersion returns the path RunCommand runs for the named tool and its module version, read from the binary.
+// The version is unknown when the tool is not a Go binary, an error is only returned when the tool is not found.
+func ToolVersion(name string) (string, string, error) {
+	toolPath, err := exec.LookPath(ToolPath(name))
+	if err != nil {
+		return "", "", err
+	}
+
+	info, err := buildinfo.ReadFile(toolPath)
+	if err != nil {
+		return toolPath, "unknown", nil
+	}
+
+	return toolPath, info.Main.Version, nil
+}
+
+// InstallBundledTools extracts the tools bundled in fsys which are not on the PATH to a private temporary directory,
+// checking their checksum and version, and makes RunCommand use them. It returns the directory, empty when every tool
+// was found on the PATH, which the caller removes once the tools are not needed anymore.
+func InstallBundledTools(ctx context.Context, fsys fs.FS) (string, error) {
+	var tools []BundledTool
+	var dir string
+
+	data, err := fs.ReadFile(fsys, ToolsManifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNoBundledTools
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the bundled tools manifest: %w", err)
+	}
+	err = json.Unmarshal(data, &tools)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the bundled tools manifest: %v", err)
+	}
+
+	for _, tool := range tools {
+		if err := ctx.Err(); err != nil {
+			return dir, err
+		}
+
+		if onPath, err := exec.LookPath(tool.Name); err == nil {
+			slog.Info("using the tool found on the PATH instead of the bundled one", "tool", tool.Name, "path", onPath)
+			continue
+		}
+
+		if dir == "" {
+			dir, err = os.MkdirTemp("", "acceptance-test-tools-")
+			if err != nil {
+				return "", fmt.Errorf("failed to create the bundled tools directory: %w", err)
+			}
+		}
+
+		toolPath, err := extractTool(fsys, dir, tool)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+
+		toolsMu.Lock()
+		toolPaths[tool.Name] = toolPath
+		toolsMu.Unlock()
+
+		slog.Info("using the bundled tool", "tool", tool.Name, "version", tool.Version, "path", toolPath)
+	}
+
+	return dir, nil
+}
+
+// extractTool writes the bundled tool to dir once its checksum is verified, then checks the version of the written binary
+func extractTool(fsys fs.FS, dir string, tool BundledTool) (string, error) {
+	data, err := fs.ReadFile(fsys, path.Join(path.Dir(ToolsManifest), tool.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the bundled %s: %w", tool.Name, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if checksum := hex.EncodeToString(sum[:]); checksum != tool.SHA256 {
+		return "", fmt.Errorf("bundled %s has checksum %s, the manifest expects %s", tool.Name, checksum, tool.SHA256)
+	}
+
+	toolPath := filepath.Join(dir, tool.Name)
+	err = os.WriteFile(toolPath, data, 0o700)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract the bundled %s: %w", tool.Name, err)
+	}
+
+	info, err := buildinfo.ReadFile(toolPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the version of the bundled %s: %v", tool.Name, err)
+	}
+	if info.Main.Version != tool.Version {
+		return "", fmt.Errorf("bundled %s is version %s, the manifest expects %s", tool.Name, info.Main.Version, tool.Version)
+	}
+	if goos, goarch := buildSetting(info, "GOOS"), buildSetting(info, "GOARCH"); goos != runtime.GOOS || goarch != runtime.GOARCH {
+		return "", fmt.Errorf("bundled %s is built for %s/%s, this binary runs on %s/%s", tool.Name, goos, goarch, runtime.GOOS, runtime.GOARCH)
+	}
+
+	return toolPath, nil
+}
+
+func buildSetting(info *debug.BuildInfo, key string) string {
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
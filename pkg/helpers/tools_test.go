@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// bundle returns a file system holding the running test binary as the bundled tool name, with its manifest
+func bundle(t *testing.T, name string, edit func(*BundledTool)) fstest.MapFS {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := buildinfo.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	tool := BundledTool{Name: name, Version: info.Main.Version, SHA256: hex.EncodeToString(sum[:])}
+	if edit != nil {
+		edit(&tool)
+	}
+	manifest, err := json.Marshal([]BundledTool{tool})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fstest.MapFS{
+		ToolsManifest:   {Data: manifest},
+		"tools/" + name: {Data: data, Mode: 0o755},
+	}
+}
+
+func TestInstallBundledTools(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    string
+		edit    func(*BundledTool)
+		wantErr string
+	}{
+		{name: "extracted", tool: "acceptance-test-bundled-tool"},
+		{name: "on the path", tool: "sh"},
+		{name: "checksum mismatch", tool: "acceptance-test-bundled-tool", edit: func(tool *BundledTool) { tool.SHA256 = "0000" }, wantErr: "checksum"},
+		{name: "version mismatch", tool: "acceptance-test-bundled-tool", edit: func(tool *BundledTool) { tool.Version = "v0.0.1" }, wantErr: "version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { delete(toolPaths, tt.tool) })
+
+			dir, err := InstallBundledTools(context.Background(), bundle(t, tt.tool, tt.edit))
+			if dir != "" {
+				t.Cleanup(func() { os.RemoveAll(dir) })
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to mention %s", err, tt.wantErr)
+				}
+				if ToolPath(tt.tool) != tt.tool {
+					t.Errorf("tool path = %s, want the tool not to be installed", ToolPath(tt.tool))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.tool == "sh" {
+				if dir != "" || ToolPath(tt.tool) != tt.tool {
+					t.Errorf("tool path = %s in %q, want the tool on the PATH to be used", ToolPath(tt.tool), dir)
+				}
+				return
+			}
+			if ToolPath(tt.tool) != filepath.Join(dir, tt.tool) {
+				t.Errorf("tool path = %s, want it extracted to %s", ToolPath(tt.tool), dir)
+			}
+			info, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o700 {
+				t.Errorf("tools directory mode = %v, want it private", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestInstallBundledToolsWithoutTools(t *testing.T) {
+	_, err := InstallBundledTools(context.Background(), fstest.MapFS{})
+	if !errors.Is(err, ErrNoBundledTools) {
+		t.Errorf("error = %v, want %v", err, ErrNoBundledTools)
+	}
+}
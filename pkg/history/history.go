@@ -0,0 +1,385 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when the store has no run with the requested ID
+var ErrNotFound = errors.New("run not found")
+
+// passedVerdict is the verdict of the runs and clusters which passed
+const passedVerdict = "PASSED"
+
+// runsBucket holds the reports of the runs keyed by their ID, a big endian sequence number
+var runsBucket = []byte("runs")
+
+// openTimeout bounds the wait for the lock another process holds on the store while it adds a run
+const openTimeout = time.Second
+
+// Store keeps the report of every run in a local BoltDB file
+type Store struct {
+	db *bolt.DB
+}
+
+// Entry is a run of the store
+type Entry struct {
+	ID     uint64
+	Report report.Report
+}
+
+// Filter selects the runs of List, empty fields match every run
+type Filter struct {
+	Operator    string
+	ImageTag    string
+	Environment string
+	// Cluster matches the runs which checked the cluster, by cluster ID or external ID
+	Cluster string
+	// Limit keeps the most recent runs only, all of them are kept when it is zero
+	Limit int
+}
+
+// Change is a difference between two runs, for the whole run when ClusterID is empty and for a cluster otherwise
+type Change struct {
+	ClusterID string
+	Kind      string
+	// Check is empty for a change of the verdict
+	Check  string
+	Before string
+	After  string
+}
+
+// Open opens the store at path, creating it and its directory if needed
+func Open(path string) (*Store, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the history directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the run history %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize the run history: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// OpenReadOnly opens the existing store at path to read it, sharing its lock with the other readers
+func OpenReadOnly(path string) (*Store, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the run history %s: %w", path, err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the run history %s: %w", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores the report of a run and returns its ID
+func (s *Store) Add(r *report.Report) (uint64, error) {
+	var id uint64
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal the report: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+
+		id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(key(id), data)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to store the run: %w", err)
+	}
+
+	return id, nil
+}
+
+// Get returns the run with the given ID
+func (s *Store) Get(id uint64) (*Entry, error) {
+	var entry *Entry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(runsBucket).Get(key(id))
+		if data == nil {
+			return fmt.Errorf("%w: %d", ErrNotFound, id)
+		}
+
+		var err error
+		entry, err = decode(id, data)
+		return err
+	})
+
+	return entry, err
+}
+
+// List returns the runs matching the filter, from the oldest to the most recent
+func (s *Store) List(filter Filter) ([]Entry, error) {
+	var entries []Entry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(runsBucket).Cursor()
+
+		// Walk back from the most recent run so the limit is reached without decoding every run
+		for k, data := cursor.Last(); k != nil; k, data = cursor.Prev() {
+			entry, err := decode(binary.BigEndian.Uint64(k), data)
+			if err != nil {
+				return err
+			}
+			if !filter.matches(&entry.Report) {
+				continue
+			}
+
+			entries = append(entries, *entry)
+			if filter.Limit > 0 && len(entries) == filter.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
+
+func (f Filter) matches(r *report.Report) bool {
+	if f.Operator != "" && r.Operator != f.Operator {
+		return false
+	}
+	if f.ImageTag != "" && r.ImageTag != f.ImageTag {
+		return false
+	}
+	if f.Environment != "" && r.Environment != f.Environment {
+		return false
+	}
+	if f.Cluster != "" && ClusterVerdict(r, f.Cluster) == "" {
+		return false
+	}
+
+	return true
+}
+
+// ClusterVerdict returns the verdict of the cluster in the run, by cluster ID or external ID, empty when the run did not check it
+func ClusterVerdict(r *report.Report, cluster string) string {
+	for _, kind := range r.Kinds {
+		for _, result := range kind.Clusters {
+			if result.ClusterID == cluster || result.ExternalID == cluster {
+				return result.Verdict
+			}
+		}
+	}
+
+	return ""
+}
+
+// FailingSince returns the run the cluster started failing in: the first of the runs, from the oldest to the most
+// recent, in which the cluster did not pass while it did not pass in any later run either. It returns false when the
+// cluster passed in the most recent run which checked it.
+func FailingSince(entries []Entry, cluster string) (Entry, bool) {
+	var since Entry
+	failing := false
+
+	for _, entry := range entries {
+		verdict := ClusterVerdict(&entry.Report, cluster)
+		switch {
+		case verdict == "":
+			continue
+		case verdict == passedVerdict:
+			failing = false
+			since = Entry{}
+		case !failing:
+			failing = true
+			since = entry
+		}
+	}
+
+	return since, failing
+}
+
+// Diff lists what changed from the run before to the run after: the verdict of the runs,
+// the verdicts of their clusters and the checks which passed in one run and not in the other
+func Diff(before, after *report.Report) []Change {
+	var changes []Change
+
+	if before.Verdict != after.Verdict {
+		changes = append(changes, Change{Before: before.Verdict, After: after.Verdict})
+	}
+
+	beforeClusters := clusters(before)
+	afterClusters := clusters(after)
+	seen := map[string]bool{}
+	for _, r := range []*report.Report{before, after} {
+		for _, kind := range r.Kinds {
+			for _, cluster := range kind.Clusters {
+				if seen[cluster.ClusterID] {
+					continue
+				}
+				seen[cluster.ClusterID] = true
+
+				b, a := beforeClusters[cluster.ClusterID], afterClusters[cluster.ClusterID]
+				changes = append(changes, diffCluster(cluster.ClusterID, cluster.Kind, b, a)...)
+			}
+		}
+	}
+
+	return changes
+}
+
+func diffCluster(clusterID, kind string, before, after *report.ClusterResult) []Change {
+	var changes []Change
+
+	beforeVerdict, afterVerdict := "not checked", "not checked"
+	beforeChecks, afterChecks := map[string]string{}, map[string]string{}
+	if before != nil {
+		beforeVerdict = before.Verdict
+		beforeChecks = checkOutcomes(before)
+	}
+	if after != nil {
+		afterVerdict = after.Verdict
+		afterChecks = checkOutcomes(after)
+	}
+
+	if beforeVerdict != afterVerdict {
+		changes = append(changes, Change{ClusterID: clusterID, Kind: kind, Before: beforeVerdict, After: afterVerdict})
+	}
+
+	for _, name := range checkOrder(before, after) {
+		b, a := beforeChecks[name], afterChecks[name]
+		if b == "" {
+			b = "not run"
+		}
+		if a == "" {
+			a = "not run"
+		}
+		if b != a {
+			changes = append(changes, Change{ClusterID: clusterID, Kind: kind, Check: name, Before: b, After: a})
+		}
+	}
+
+	return changes
+}
+
+// checkOutcomes returns the outcome of every check and baseline comparison of the cluster
+func checkOutcomes(cluster *report.ClusterResult) map[string]string {
+	outcomes := map[string]string{}
+
+	for _, check := range cluster.Checks {
+		outcomes[check.Name] = "failed"
+		if check.Passed {
+			outcomes[check.Name] = "passed"
+		}
+	}
+	for _, comparison := range cluster.Comparisons {
+		outcomes["baseline "+comparison.Signal] = "passed"
+		if comparison.Regressed {
+			outcomes["baseline "+comparison.Signal] = "regressed"
+		}
+	}
+
+	return outcomes
+}
+
+// checkOrder lists the checks of both results once, in the order they ran, the checks of the later result first
+func checkOrder(before, after *report.ClusterResult) []string {
+	var names []string
+	seen := map[string]bool{}
+
+	for _, cluster := range []*report.ClusterResult{after, before} {
+		if cluster == nil {
+			continue
+		}
+		for _, name := range checkNames(cluster) {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	return names
+}
+
+// checkNames lists the checks and baseline comparisons of the cluster in the order they ran
+func checkNames(cluster *report.ClusterResult) []string {
+	var names []string
+
+	for _, check := range cluster.Checks {
+		names = append(names, check.Name)
+	}
+	for _, comparison := range cluster.Comparisons {
+		names = append(names, "baseline "+comparison.Signal)
+	}
+
+	return names
+}
+
+// clusters indexes the clusters of the run by cluster ID
+func clusters(r *report.Report) map[string]*report.ClusterResult {
+	indexed := map[string]*report.ClusterResult{}
+
+	for i := range r.Kinds {
+		for j := range r.Kinds[i].Clusters {
+			cluster := &r.Kinds[i].Clusters[j]
+			indexed[cluster.ClusterID] = cluster
+		}
+	}
+
+	return indexed
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+
+	return k
+}
+
+func decode(id uint64, data []byte) (*Entry, error) {
+	entry := &Entry{ID: id}
+
+	err := json.Unmarshal(data, &entry.Report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse run %d of the history: %w", id, err)
+	}
+
+	return entry, nil
+}
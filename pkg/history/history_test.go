@@ -0,0 +1,200 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+)
+
+// run returns the report of a run which checked c-1 with the given verdict
+func run(operator, imageTag, verdict string, start time.Time) *report.Report {
+	return &report.Report{
+		Operator:    operator,
+		ImageTag:    imageTag,
+		Environment: "stage",
+		Verdict:     verdict,
+		StartTime:   start,
+		Kinds: []report.KindResult{{
+			Kind:    "ManagementCluster",
+			Verdict: verdict,
+			Clusters: []report.ClusterResult{{
+				ClusterID:  "c-1",
+				Kind:       "ManagementCluster",
+				ExternalID: "ext-1",
+				Verdict:    verdict,
+				Checks:     []report.CheckResult{{Name: "liveness", Passed: verdict == passedVerdict}},
+			}},
+		}},
+	}
+}
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := Open(filepath.Join(t.TempDir(), "history", "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestStore(t *testing.T) {
+	store := openStore(t)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	runs := []*report.Report{
+		run("hypershift-operator", "aaa", "PASSED", start),
+		run("hypershift-operator", "bbb", "FAILED", start.Add(time.Hour)),
+		run("other-operator", "aaa", "PASSED", start.Add(2*time.Hour)),
+		run("hypershift-operator", "ccc", "FAILED", start.Add(3*time.Hour)),
+	}
+	for i, r := range runs {
+		id, err := store.Add(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != uint64(i+1) {
+			t.Errorf("Add() = %d, want %d", id, i+1)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{name: "every run", filter: Filter{}, want: []uint64{1, 2, 3, 4}},
+		{name: "operator", filter: Filter{Operator: "hypershift-operator"}, want: []uint64{1, 2, 4}},
+		{name: "imagetag", filter: Filter{ImageTag: "aaa"}, want: []uint64{1, 3}},
+		{name: "environment", filter: Filter{Environment: "production"}, want: nil},
+		{name: "external ID", filter: Filter{Cluster: "ext-1", Operator: "other-operator"}, want: []uint64{3}},
+		{name: "limit keeps the most recent runs", filter: Filter{Operator: "hypershift-operator", Limit: 2}, want: []uint64{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := store.List(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []uint64
+			for _, entry := range entries {
+				ids = append(ids, entry.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("List(%+v) = %v, want %v", tt.filter, ids, tt.want)
+			}
+		})
+	}
+
+	entry, err := store.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Report.ImageTag != "bbb" || !entry.Report.StartTime.Equal(start.Add(time.Hour)) {
+		t.Errorf("Get(2) = %+v, want the second run", entry.Report)
+	}
+
+	_, err = store.Get(42)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(42) error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	_, err := OpenReadOnly(path)
+	if err == nil {
+		t.Fatal("OpenReadOnly() of a missing store succeeded, want an error")
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Add(run("hypershift-operator", "aaa", "PASSED", time.Now()))
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The readers share the store
+	first, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() while another reader has the store open = %v", err)
+	}
+	defer second.Close()
+
+	entries, err := second.List(Filter{})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("List() = %v, %v, want the stored run", entries, err)
+	}
+	_, err = first.Add(run("hypershift-operator", "bbb", "PASSED", time.Now()))
+	if err == nil {
+		t.Error("Add() to a read-only store succeeded, want an error")
+	}
+}
+
+func TestFailingSince(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	entries := func(verdicts ...string) []Entry {
+		var entries []Entry
+		for i, verdict := range verdicts {
+			entries = append(entries, Entry{ID: uint64(i + 1), Report: *run("hypershift-operator", "aaa", verdict, start)})
+		}
+		return entries
+	}
+
+	tests := []struct {
+		name     string
+		entries  []Entry
+		want     uint64
+		wantFail bool
+	}{
+		{name: "passing", entries: entries("FAILED", "PASSED"), wantFail: false},
+		{name: "failing since the last pass", entries: entries("FAILED", "PASSED", "FAILED", "ERRORED"), want: 3, wantFail: true},
+		{name: "never passed", entries: entries("FAILED", "FAILED"), want: 1, wantFail: true},
+		{name: "no runs", entries: nil, wantFail: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			since, failing := FailingSince(tt.entries, "c-1")
+			if failing != tt.wantFail || since.ID != tt.want {
+				t.Errorf("FailingSince() = %d, %v, want %d, %v", since.ID, failing, tt.want, tt.wantFail)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	before := run("hypershift-operator", "aaa", "PASSED", start)
+	after := run("hypershift-operator", "bbb", "FAILED", start)
+	after.Kinds[0].Clusters = append(after.Kinds[0].Clusters, report.ClusterResult{ClusterID: "c-2", Kind: "ManagementCluster", Verdict: "PASSED"})
+
+	want := []Change{
+		{Before: "PASSED", After: "FAILED"},
+		{ClusterID: "c-1", Kind: "ManagementCluster", Before: "PASSED", After: "FAILED"},
+		{ClusterID: "c-1", Kind: "ManagementCluster", Check: "liveness", Before: "passed", After: "failed"},
+		{ClusterID: "c-2", Kind: "ManagementCluster", Before: "not checked", After: "PASSED"},
+	}
+	if got := Diff(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+
+	if got := Diff(before, before); len(got) != 0 {
+		t.Errorf("Diff() of the same run = %+v, want no change", got)
+	}
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+var levelName = "info"
+
+type loggerKey struct{}
+
+// Setup configures the default slog logger used by the ocm, telemeter and workflows packages.
+// level is one of debug, info, warn or error and format is either text or json.
+// The logs go to the standard error, leaving the standard output to the plan and the reports.
+func Setup(level, format string) error {
+	return SetupWithWriter(os.Stderr, level, format)
+}
+
+// SetupWithWriter is like Setup but writes the log records to w
+func SetupWithWriter(w io.Writer, level, format string) error {
+	logger, err := New(w, level, format)
+	if err != nil {
+		return err
+	}
+
+	levelName = strings.ToLower(level)
+	slog.SetDefault(logger)
+
+	return nil
+}
+
+// New returns a logger writing to w with the given level and format, see Setup
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	slogLevel, ok := levels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("invalid log level %q, valid levels are debug, info, warn and error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, valid formats are text and json", format)
+	}
+}
+
+// WithLogger returns a copy of ctx carrying the logger the workflows log the run to
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// LevelName returns the configured log level, used to keep the obsctl log level in sync
+func LevelName() string {
+	return levelName
+}
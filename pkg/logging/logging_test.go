@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		level   string
+		format  string
+		logged  []slog.Level
+		invalid bool
+	}{
+		{level: "debug", format: "text", logged: []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}},
+		{level: "info", format: "json", logged: []slog.Level{slog.LevelInfo, slog.LevelWarn, slog.LevelError}},
+		{level: "WARN", format: "JSON", logged: []slog.Level{slog.LevelWarn, slog.LevelError}},
+		{level: "error", format: "text", logged: []slog.Level{slog.LevelError}},
+		{level: "trace", format: "text", invalid: true},
+		{level: "", format: "text", invalid: true},
+		{level: "info", format: "logfmt", invalid: true},
+		{level: "info", format: "", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level+" "+tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			logger, err := New(&buf, tt.level, tt.format)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("New(%q, %q) succeeded, want an error", tt.level, tt.format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+				logger.Log(context.Background(), level, "message")
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != len(tt.logged) {
+				t.Fatalf("logged %d records, want %d:\n%s", len(lines), len(tt.logged), buf.String())
+			}
+			for i, line := range lines {
+				var level string
+				if strings.EqualFold(tt.format, "json") {
+					var record map[string]interface{}
+					err := json.Unmarshal([]byte(line), &record)
+					if err != nil {
+						t.Fatalf("record %q is not JSON: %v", line, err)
+					}
+					level, _ = record["level"].(string)
+				} else if _, after, ok := strings.Cut(line, "level="); ok {
+					level, _, _ = strings.Cut(after, " ")
+				}
+				if level != tt.logged[i].String() {
+					t.Errorf("record %q has level %q, want %s", line, level, tt.logged[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSetupWithWriter(t *testing.T) {
+	defaultLogger, defaultLevel := slog.Default(), levelName
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		levelName = defaultLevel
+	})
+
+	var buf bytes.Buffer
+	err := SetupWithWriter(&buf, "Debug", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if LevelName() != "debug" {
+		t.Errorf("level name = %q, want debug", LevelName())
+	}
+
+	FromContext(context.Background()).Debug("message")
+	if !strings.Contains(buf.String(), "level=DEBUG msg=message") {
+		t.Errorf("default logger wrote %q, want the debug record", buf.String())
+	}
+
+	err = SetupWithWriter(&buf, "verbose", "text")
+	if err == nil || LevelName() != "debug" {
+		t.Errorf("invalid level = %v with level name %q, want an error leaving debug", err, LevelName())
+	}
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Job is the job the metrics of a run are pushed under
+const Job = "acceptance_test"
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+type metricsKey struct{}
+
+// Metrics holds the metrics of the runs, the acceptance test infrastructure is alerted on.
+// A nil *Metrics records nothing, so the runs without metrics do not need to check for it.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	runs            *prometheus.CounterVec
+	runDuration     *prometheus.HistogramVec
+	phaseDuration   *prometheus.HistogramVec
+	clustersChecked *prometheus.CounterVec
+	clustersFailed  *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+}
+
+// New returns the metrics of the runs in a registry of their own
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "acceptance_test_runs_total",
+			Help: "Runs completed, by environment, mode and verdict.",
+		}, []string{"environment", "mode", "verdict"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "acceptance_test_run_duration_seconds",
+			Help:    "Duration of the runs, from the setup to the end of the cleanup.",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+		}, []string{"environment", "mode"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "acceptance_test_phase_duration_seconds",
+			Help:    "Duration of the phases of the runs: setup, acceptance, canary and cleanup.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"phase"}),
+		clustersChecked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "acceptance_test_clusters_checked_total",
+			Help: "Clusters checked by the acceptance test, by kind and verdict.",
+		}, []string{"kind", "verdict"}),
+		clustersFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "acceptance_test_clusters_failed_total",
+			Help: "Clusters which did not pass the acceptance test, by kind, verdict and the check which did not pass.",
+		}, []string{"kind", "verdict", "reason"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "acceptance_test_query_duration_seconds",
+			Help:    "Latency of every attempt of the OCM API calls and Telemeter queries, by backend and outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"backend", "outcome"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "acceptance_test_retries_total",
+			Help: "Retries of the ocm and obsctl operations failing with a transient error, by operation.",
+		}, []string{"operation"}),
+	}
+
+	m.registry.MustRegister(m.runs, m.runDuration, m.phaseDuration, m.clustersChecked, m.clustersFailed, m.queryDuration, m.retries)
+
+	return m
+}
+
+// WithMetrics returns a copy of ctx where the runs record their metrics in m
+func WithMetrics(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// From returns the metrics stored in ctx, nil when there are none
+func From(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(metricsKey{}).(*Metrics)
+
+	return m
+}
+
+// Handler returns the handler exposing the metrics to Prometheus
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Push replaces the metrics of the group in the Pushgateway at url with m, grouping is a list of label names and values.
+// The grouping labels must not be labels of the metrics, e.g. environment.
+func (m *Metrics) Push(ctx context.Context, url string, grouping ...string) error {
+	pusher := push.New(url, Job).Gatherer(m.registry)
+	for i := 0; i+1 < len(grouping); i += 2 {
+		pusher = pusher.Grouping(grouping[i], grouping[i+1])
+	}
+
+	err := pusher.PushContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to push the metrics: %w", err)
+	}
+
+	return nil
+}
+
+// ObserveRun records a completed run
+func (m *Metrics) ObserveRun(environment, mode, verdict string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.runs.WithLabelValues(environment, mode, verdict).Inc()
+	m.runDuration.WithLabelValues(environment, mode).Observe(duration.Seconds())
+}
+
+// ObservePhase records the duration of a phase of a run
+func (m *Metrics) ObservePhase(phase string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.phaseDuration.WithLabelValues(phase).Observe(duration.Seconds())
+}
+
+// ObserveCluster records the verdict of a cluster, reason names what kept it from passing and is ignored when it passed
+func (m *Metrics) ObserveCluster(kind, verdict, reason string, passed bool) {
+	if m == nil {
+		return
+	}
+
+	m.clustersChecked.WithLabelValues(kind, verdict).Inc()
+	if !passed {
+		m.clustersFailed.WithLabelValues(kind, verdict, reason).Inc()
+	}
+}
+
+// ObserveQuery records the latency of an attempt of a call to backend, ocm or telemeter, which started at start
+func (m *Metrics) ObserveQuery(backend string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	m.queryDuration.WithLabelValues(backend, outcome).Observe(time.Since(start).Seconds())
+}
+
+// Retry records a retry of operation
+func (m *Metrics) Retry(operation string) {
+	if m == nil {
+		return
+	}
+
+	m.retries.WithLabelValues(operationName(operation)).Inc()
+}
+
+// operationName drops the API path of an operation, e.g. the cluster ID in "ocm get /api/clusters_mgmt/v1/clusters/<id>",
+// so that the operations are a handful of label values
+func operationName(operation string) string {
+	name, _, _ := strings.Cut(operation, " /")
+
+	return name
+}
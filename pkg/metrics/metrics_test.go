@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the metrics exposed by the handler of m
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	return recorder.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	ctx := WithMetrics(context.Background(), m)
+
+	From(ctx).ObserveRun("stage", "acceptance", "FAILED", 90*time.Second)
+	From(ctx).ObservePhase("setup", 3*time.Second)
+	From(ctx).ObserveCluster("ManagementCluster", "PASSED", "", true)
+	From(ctx).ObserveCluster("ServiceCluster", "FAILED", "csv_abnormal", false)
+	From(ctx).ObserveQuery("telemeter", time.Now(), nil)
+	From(ctx).ObserveQuery("ocm", time.Now(), errors.New("status is 503"))
+	From(ctx).Retry("ocm get /api/clusters_mgmt/v1/clusters/c-1")
+	From(ctx).Retry("obsctl metrics query")
+
+	exposed := scrape(t, m)
+	for _, want := range []string{
+		`acceptance_test_runs_total{environment="stage",mode="acceptance",verdict="FAILED"} 1`,
+		`acceptance_test_run_duration_seconds_sum{environment="stage",mode="acceptance"} 90`,
+		`acceptance_test_phase_duration_seconds_count{phase="setup"} 1`,
+		`acceptance_test_clusters_checked_total{kind="ManagementCluster",verdict="PASSED"} 1`,
+		`acceptance_test_clusters_checked_total{kind="ServiceCluster",verdict="FAILED"} 1`,
+		`acceptance_test_clusters_failed_total{kind="ServiceCluster",reason="csv_abnormal",verdict="FAILED"} 1`,
+		`acceptance_test_query_duration_seconds_count{backend="telemeter",outcome="success"} 1`,
+		`acceptance_test_query_duration_seconds_count{backend="ocm",outcome="error"} 1`,
+		`acceptance_test_retries_total{operation="ocm get"} 1`,
+		`acceptance_test_retries_total{operation="obsctl metrics query"} 1`,
+	} {
+		if !strings.Contains(exposed, want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, exposed)
+		}
+	}
+	if strings.Contains(exposed, `clusters_failed_total{kind="ManagementCluster"`) {
+		t.Errorf("metrics count a passed cluster as failed:\n%s", exposed)
+	}
+}
+
+func TestMetricsWithoutRegistry(t *testing.T) {
+	// The runs without metrics record nothing instead of failing
+	m := From(context.Background())
+	m.ObserveRun("stage", "acceptance", "PASSED", time.Second)
+	m.ObservePhase("setup", time.Second)
+	m.ObserveCluster("ManagementCluster", "PASSED", "", true)
+	m.ObserveQuery("ocm", time.Now(), nil)
+	m.Retry("ocm login")
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	t.Cleanup(gateway.Close)
+
+	m := New()
+	m.ObserveRun("stage", "acceptance", "PASSED", time.Minute)
+
+	err := m.Push(context.Background(), gateway.URL, "operator", "hypershift-operator", "env", "stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/acceptance_test/operator/hypershift-operator/env/stage" {
+		t.Errorf("push = %s %s, want the run group to be replaced", method, path)
+	}
+	if !strings.Contains(body, "acceptance_test_runs_total") {
+		t.Errorf("pushed body does not contain the runs")
+	}
+
+	gateway.Close()
+	err = m.Push(context.Background(), gateway.URL, "operator", "hypershift-operator")
+	if err == nil {
+		t.Error("Push() to a stopped Pushgateway succeeded, want an error")
+	}
+}
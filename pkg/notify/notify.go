@@ -0,0 +1,250 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+)
+
+const (
+	EventStarted   = "started"
+	EventFailed    = "failed"
+	EventCompleted = "completed"
+)
+
+// passedVerdict is the verdict of the clusters which passed, the other ones are listed on completion
+const passedVerdict = "PASSED"
+
+// sendTimeout bounds every post to a sink so a slow sink cannot hold the run
+const sendTimeout = 10 * time.Second
+
+type notifierKey struct{}
+
+// Default templates of the messages, rendered with an Event
+const (
+	DefaultStartedTemplate = `Acceptance test of {{.Operator}}:{{.ImageTag}} started in {{.Environment}} on {{join .Selectors ", "}} ({{.Mode}})`
+	DefaultFailedTemplate  = `Acceptance test of {{.Operator}}:{{.ImageTag}} in {{.Environment}} hit its first failure` +
+		`{{with .Failure}}{{if .ClusterID}} on {{.Kind}} {{.ClusterID}} ({{.ExternalID}}){{end}}: {{.Reason}}{{end}}`
+	DefaultCompletedTemplate = `Acceptance test of {{.Operator}}:{{.ImageTag}} in {{.Environment}} {{.Verdict}} after {{.Duration}}` +
+		`{{range .FailingClusters}}` + "\n" + `- {{.Kind}} {{.ClusterID}} ({{.ExternalID}}): {{.Verdict}}{{end}}` +
+		`{{if not .FailingClusters}}{{range .Errors}}` + "\n" + `- {{.}}{{end}}{{end}}`
+)
+
+// Event is a notification about a run, sinks post it along with its rendered Text
+type Event struct {
+	Type        string    `json:"event"`
+	Operator    string    `json:"operator"`
+	ImageTag    string    `json:"imagetag"`
+	Environment string    `json:"environment"`
+	Selectors   []string  `json:"selectors"`
+	Mode        string    `json:"mode"`
+	StartTime   time.Time `json:"start_time"`
+	// Verdict and Duration are only set on completion
+	Verdict  string `json:"verdict,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// Failure is the first failure of the run, only set on the failed event
+	Failure *Failure `json:"failure,omitempty"`
+	// FailingClusters are the clusters which did not pass, only set on completion
+	FailingClusters []Failure `json:"failing_clusters,omitempty"`
+	Errors          []string  `json:"errors,omitempty"`
+	Text            string    `json:"text"`
+}
+
+// Failure is a cluster which did not pass, the cluster fields are empty for failures of the whole run
+type Failure struct {
+	ClusterID  string `json:"cluster_id,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+	Verdict    string `json:"verdict,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Templates are the text/template sources of the messages of each event, the default template is used when one is empty
+type Templates struct {
+	Started   string
+	Failed    string
+	Completed string
+}
+
+// Notifier posts the events of a single run to its sinks: when it starts, on its first failure and when it completes.
+// A nil Notifier sends nothing.
+type Notifier struct {
+	sinks     []Sink
+	templates map[string]*template.Template
+	scrubber  helpers.Scrubber
+
+	mu     sync.Mutex
+	run    Event
+	failed bool
+}
+
+// New returns a notifier posting to sinks with the messages rendered from templates.
+// The secrets are replaced with REDACTED wherever they would appear in a notification or in the error of a sink.
+func New(sinks []Sink, templates Templates, secrets ...string) (*Notifier, error) {
+	n := &Notifier{sinks: sinks, templates: map[string]*template.Template{}, scrubber: helpers.NewScrubber(secrets...)}
+
+	sources := []struct {
+		event, source, fallback string
+	}{
+		{EventStarted, templates.Started, DefaultStartedTemplate},
+		{EventFailed, templates.Failed, DefaultFailedTemplate},
+		{EventCompleted, templates.Completed, DefaultCompletedTemplate},
+	}
+	for _, s := range sources {
+		source := s.source
+		if source == "" {
+			source = s.fallback
+		}
+
+		tmpl, err := template.New(s.event).Funcs(template.FuncMap{"join": strings.Join}).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s notification template: %v", s.event, err)
+		}
+		// Unknown fields only show up once the template is executed
+		err = tmpl.Execute(&bytes.Buffer{}, Event{Failure: &Failure{}})
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s notification template: %v", s.event, err)
+		}
+		n.templates[s.event] = tmpl
+	}
+
+	return n, nil
+}
+
+// WithNotifier returns a copy of ctx carrying the notifier Fail reports the failures of the workflows to
+func WithNotifier(ctx context.Context, n *Notifier) context.Context {
+	return context.WithValue(ctx, notifierKey{}, n)
+}
+
+// From returns the notifier stored in ctx, nil if there is none
+func From(ctx context.Context) *Notifier {
+	n, _ := ctx.Value(notifierKey{}).(*Notifier)
+	return n
+}
+
+// Fail notifies the failure to the notifier stored in ctx, if any
+func Fail(ctx context.Context, failure Failure) {
+	From(ctx).Fail(ctx, failure)
+}
+
+// Start notifies the start of the run described by the header of r
+func (n *Notifier) Start(ctx context.Context, r *report.Report) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	n.run = Event{
+		Operator:    r.Operator,
+		ImageTag:    r.ImageTag,
+		Environment: r.Environment,
+		Selectors:   r.Selectors,
+		Mode:        r.Mode,
+		StartTime:   r.StartTime,
+	}
+	event := n.run
+	n.mu.Unlock()
+
+	event.Type = EventStarted
+	n.send(ctx, event)
+}
+
+// Fail notifies the first failure of the run, the following ones only show up on completion
+func (n *Notifier) Fail(ctx context.Context, failure Failure) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	if n.failed {
+		n.mu.Unlock()
+		return
+	}
+	n.failed = true
+	event := n.run
+	n.mu.Unlock()
+
+	event.Type = EventFailed
+	event.Failure = &failure
+	n.send(ctx, event)
+}
+
+// Complete notifies the verdict of the run along with the clusters which did not pass
+func (n *Notifier) Complete(ctx context.Context, r *report.Report) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	event := n.run
+	n.mu.Unlock()
+
+	event.Type = EventCompleted
+	event.Verdict = r.Verdict
+	event.Duration = r.EndTime.Sub(r.StartTime).Round(time.Second).String()
+	event.Errors = r.Errors
+	for _, kind := range r.Kinds {
+		for _, cluster := range kind.Clusters {
+			if cluster.Verdict == passedVerdict {
+				continue
+			}
+			event.FailingClusters = append(event.FailingClusters, Failure{
+				ClusterID:  cluster.ClusterID,
+				Kind:       cluster.Kind,
+				ExternalID: cluster.ExternalID,
+				Verdict:    cluster.Verdict,
+			})
+		}
+	}
+
+	n.send(ctx, event)
+}
+
+// send renders the message of the event and posts it to every sink, a failing sink is only logged
+func (n *Notifier) send(ctx context.Context, event Event) {
+	event = n.redact(event)
+
+	var text bytes.Buffer
+	err := n.templates[event.Type].Execute(&text, event)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to render the notification", "event", event.Type, "error", err)
+		return
+	}
+	event.Text = n.scrubber.Scrub(strings.TrimSpace(text.String()))
+
+	for _, sink := range n.sinks {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sink.Send(sendCtx, event)
+		cancel()
+		if err != nil {
+			logging.FromContext(ctx).Warn("failed to send the notification", "event", event.Type, "sink", sink.Name(), "error", n.scrubber.Scrub(err.Error()))
+		}
+	}
+}
+
+// redact scrubs the secrets from the free text fields of the event, which carry error messages
+func (n *Notifier) redact(event Event) Event {
+	if event.Failure != nil {
+		failure := *event.Failure
+		failure.Reason = n.scrubber.Scrub(failure.Reason)
+		event.Failure = &failure
+	}
+
+	if len(event.Errors) > 0 {
+		errs := make([]string, 0, len(event.Errors))
+		for _, err := range event.Errors {
+			errs = append(errs, n.scrubber.Scrub(err))
+		}
+		event.Errors = errs
+	}
+
+	return event
+}
@@ -0,0 +1,187 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+)
+
+// webhook is a local stand-in for a webhook, recording the bodies posted to each path
+type webhook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies map[string][]string
+	status int
+}
+
+func newWebhook(t *testing.T) *webhook {
+	w := &webhook{bodies: map[string][]string{}, status: http.StatusOK}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.bodies[r.URL.Path] = append(w.bodies[r.URL.Path], string(body))
+		rw.WriteHeader(w.status)
+	}))
+	t.Cleanup(w.Close)
+
+	return w
+}
+
+func (w *webhook) posted(path string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.bodies[path]
+}
+
+func testRun() *report.Report {
+	start := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	return &report.Report{
+		Operator:    "example-operator",
+		ImageTag:    "abc123",
+		Environment: "stage",
+		Selectors:   []string{"us-east-1", "main"},
+		Mode:        "acceptance",
+		StartTime:   start,
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	ctx := context.Background()
+	server := newWebhook(t)
+	slackURL := server.URL + "/services/T000/B000/slack-secret-path"
+
+	notifier, err := notify.New([]notify.Sink{notify.NewWebhookSink(server.URL + "/hook"), notify.NewSlackSink(slackURL)},
+		notify.Templates{}, "super-secret-token", slackURL)
+	if err != nil {
+		t.Fatalf("failed to create the notifier: %v", err)
+	}
+
+	run := testRun()
+	notifier.Start(ctx, run)
+
+	ctx = notify.WithNotifier(ctx, notifier)
+	notify.Fail(ctx, notify.Failure{ClusterID: "sc-1", Kind: "service", ExternalID: "ext-sc-1", Verdict: "FAILED",
+		Reason: "login with super-secret-token refused"})
+	notify.Fail(ctx, notify.Failure{ClusterID: "mc-1", Kind: "management", ExternalID: "ext-mc-1", Verdict: "FAILED"})
+
+	run.Verdict = "FAILED"
+	run.EndTime = run.StartTime.Add(90 * time.Second)
+	run.Kinds = []report.KindResult{{Kind: "service", Clusters: []report.ClusterResult{
+		{ClusterID: "sc-1", Kind: "service", ExternalID: "ext-sc-1", Verdict: "FAILED"},
+		{ClusterID: "sc-2", Kind: "service", ExternalID: "ext-sc-2", Verdict: "PASSED"},
+	}}}
+	run.Errors = []string{"csv_abnormal count is greater than 0 for cluster ext-sc-1"}
+	notifier.Complete(ctx, run)
+
+	var events []notify.Event
+	for _, body := range server.posted("/hook") {
+		var event notify.Event
+		err := json.Unmarshal([]byte(body), &event)
+		if err != nil {
+			t.Fatalf("failed to parse the webhook payload %s: %v", body, err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 3 {
+		t.Fatalf("webhook got %d events, want the start, the first failure and the completion: %v", len(events), server.posted("/hook"))
+	}
+
+	for i, want := range []string{notify.EventStarted, notify.EventFailed, notify.EventCompleted} {
+		if events[i].Type != want || events[i].Operator != "example-operator" || events[i].Text == "" {
+			t.Errorf("event %d = %+v, want a %s event of the run", i, events[i], want)
+		}
+	}
+	if failure := events[1].Failure; failure == nil || failure.ClusterID != "sc-1" || failure.Reason != "login with REDACTED refused" {
+		t.Errorf("failure = %+v, want the first failure with the token redacted", failure)
+	}
+	if clusters := events[2].FailingClusters; len(clusters) != 1 || clusters[0].ClusterID != "sc-1" {
+		t.Errorf("failing clusters = %+v, want sc-1", clusters)
+	}
+
+	slack := server.posted("/services/T000/B000/slack-secret-path")
+	if len(slack) != 3 {
+		t.Fatalf("slack got %d messages, want 3", len(slack))
+	}
+	wantTexts := []string{
+		"Acceptance test of example-operator:abc123 started in stage on us-east-1, main (acceptance)",
+		"Acceptance test of example-operator:abc123 in stage hit its first failure on service sc-1 (ext-sc-1): login with REDACTED refused",
+		"Acceptance test of example-operator:abc123 in stage FAILED after 1m30s\n- service sc-1 (ext-sc-1): FAILED",
+	}
+	for i, body := range slack {
+		var message map[string]string
+		err := json.Unmarshal([]byte(body), &message)
+		if err != nil {
+			t.Fatalf("failed to parse the slack payload %s: %v", body, err)
+		}
+		if len(message) != 1 || message["text"] != wantTexts[i] {
+			t.Errorf("slack payload = %s, want the text %q", body, wantTexts[i])
+		}
+	}
+
+	for _, body := range append(server.posted("/hook"), slack...) {
+		if strings.Contains(body, "super-secret-token") || strings.Contains(body, "slack-secret-path") {
+			t.Errorf("notification leaks a secret: %s", body)
+		}
+	}
+}
+
+func TestNotifierTemplates(t *testing.T) {
+	server := newWebhook(t)
+
+	notifier, err := notify.New([]notify.Sink{notify.NewSlackSink(server.URL)},
+		notify.Templates{Completed: "{{.Verdict}}:{{range .FailingClusters}} {{.ExternalID}}{{end}}"})
+	if err != nil {
+		t.Fatalf("failed to create the notifier: %v", err)
+	}
+
+	run := testRun()
+	run.Verdict = "INCONCLUSIVE"
+	run.Kinds = []report.KindResult{{Kind: "management", Clusters: []report.ClusterResult{
+		{ClusterID: "mc-1", ExternalID: "ext-mc-1", Verdict: "INCONCLUSIVE"},
+		{ClusterID: "mc-2", ExternalID: "ext-mc-2", Verdict: "ERRORED"},
+	}}}
+	notifier.Complete(context.Background(), run)
+
+	if got := server.posted("/"); len(got) != 1 || got[0] != `{"text":"INCONCLUSIVE: ext-mc-1 ext-mc-2"}` {
+		t.Errorf("slack payloads = %v, want the custom completion message", got)
+	}
+}
+
+func TestNewInvalidTemplate(t *testing.T) {
+	for _, templates := range []notify.Templates{
+		{Started: "{{.Operator"},
+		{Failed: "{{.Failure.Cluster}}"},
+	} {
+		_, err := notify.New(nil, templates)
+		if err == nil {
+			t.Errorf("New(%+v) succeeded, want an error", templates)
+		}
+	}
+}
+
+func TestSinkErrorHidesURL(t *testing.T) {
+	server := newWebhook(t)
+	server.status = http.StatusForbidden
+
+	for _, sink := range []notify.Sink{notify.NewWebhookSink(server.URL + "/hook-secret"), notify.NewWebhookSink("http://127.0.0.1:1/hook-secret")} {
+		err := sink.Send(context.Background(), notify.Event{Type: notify.EventStarted})
+		if err == nil {
+			t.Fatalf("%s sink succeeded, want an error", sink.Name())
+		}
+		if strings.Contains(err.Error(), "hook-secret") {
+			t.Errorf("error %q leaks the webhook URL", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Sink posts the events of a run somewhere
+type Sink interface {
+	// Name identifies the sink in the logs, the URL of a webhook is a secret and is never used for it
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// WebhookSink posts every event as JSON to a generic webhook
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// SlackSink posts the message of every event to a Slack incoming webhook
+type SlackSink struct {
+	url    string
+	client *http.Client
+}
+
+// slackMessage is the payload of a Slack incoming webhook
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// NewWebhookSink returns a sink posting the events as JSON to url
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: http.DefaultClient}
+}
+
+// NewSlackSink returns a sink posting the messages to the Slack incoming webhook url
+func NewSlackSink(url string) *SlackSink {
+	return &SlackSink{url: url, client: http.DefaultClient}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Send posts the event with its message
+func (s *WebhookSink) Send(ctx context.Context, event Event) error {
+	return post(ctx, s.client, s.url, event)
+}
+
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+// Send posts the message of the event
+func (s *SlackSink) Send(ctx context.Context, event Event) error {
+	return post(ctx, s.client, s.url, slackMessage{Text: event.Text})
+}
+
+// post sends payload as JSON to target. The errors leave the URL out as webhook URLs embed their credentials.
+func post(ctx context.Context, client *http.Client, target string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode the notification: %v", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to post the notification: %w", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+
+	return nil
+}
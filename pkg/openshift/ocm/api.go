@@ -0,0 +1,118 @@
+package ocm
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	ocmsdk "github.com/openshift-online/ocm-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Connect makes the ocm package send its requests to apiURL through the ocm-sdk instead of the ocm cli.
+// It is meant for talking to a local stand-in of the OCM API, the acceptance test itself relies on the
+// ocm cli login which also configures backplane. The retries are left to the retry package.
+func Connect(ctx context.Context, apiURL, token string) error {
+	connection, err := ocmsdk.NewConnectionBuilder().
+		URL(apiURL).
+		Tokens(token).
+		RetryLimit(0).
+		BuildContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to OCM at %s: %w", apiURL, err)
+	}
+
+	Ocm = &ocmClient{connection}
+
+	return nil
+}
+
+// Disconnect closes the connection opened by Connect, the ocm cli is used again afterwards
+func Disconnect() error {
+	if Ocm == nil {
+		return nil
+	}
+
+	err := Ocm.Close()
+	Ocm = nil
+
+	return err
+}
+
+// apiGet fetches an OCM API path and returns the JSON body, retrying transient failures.
+// The request goes through the ocm-sdk connection when Connect was called and through ocm get otherwise,
+// unless it is served from a recording.
+func apiGet(ctx context.Context, path string, parameters url.Values) ([]byte, error) {
+	var body []byte
+
+	request := "GET " + path
+	if len(parameters) > 0 {
+		request += "?" + parameters.Encode()
+	}
+
+	ctx, span := tracing.Start(ctx, "ocm get", attribute.String("ocm.path", path), attribute.String("ocm.parameters", parameters.Encode()))
+	err := retry.Do(ctx, "ocm get "+path, func(ctx context.Context) error {
+		var err error
+
+		start := time.Now()
+		body, err = replay.Do(ctx, "ocm", request, func(ctx context.Context) ([]byte, error) {
+			if Ocm != nil {
+				return Ocm.get(ctx, path, parameters)
+			}
+
+			return cliGet(ctx, path, parameters)
+		})
+		metrics.From(ctx).ObserveQuery("ocm", start, err)
+
+		return err
+	})
+	tracing.End(span, err)
+
+	return body, err
+}
+
+// cliGet fetches an OCM API path with ocm get
+func cliGet(ctx context.Context, path string, parameters url.Values) ([]byte, error) {
+	args := []string{"get", path}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--parameter", name+"="+parameters.Get(name))
+	}
+
+	stdout, stderr, err := helpers.RunCommand(ctx, "ocm", args)
+	if err != nil {
+		return nil, fmt.Errorf("error executing ocm get %s: %w\nStandard Error: %s", path, err, stderr)
+	}
+
+	return stdout, nil
+}
+
+func (c *ocmClient) get(ctx context.Context, path string, parameters url.Values) ([]byte, error) {
+	request := c.Get().Path(path)
+	for name := range parameters {
+		request.Parameter(name, parameters.Get(name))
+	}
+
+	response, err := request.SendContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to %s: %w", path, err)
+	}
+
+	// The message mirrors the ocm cli so that retryable statuses are recognised the same way
+	if response.Status() >= 400 {
+		return nil, fmt.Errorf("error getting %s: status is %d: %s", path, response.Status(), response.Bytes())
+	}
+
+	return response.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package ocm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Inventory is a snapshot of the fleet listings along with the external IDs of the clusters,
+// it lets a run be planned without OCM credentials
+type Inventory struct {
+	Environment        string            `json:"environment"`
+	Time               time.Time         `json:"time"`
+	ManagementClusters []Item            `json:"management_clusters"`
+	ServiceClusters    []Item            `json:"service_clusters"`
+	ExternalIDs        map[string]string `json:"external_ids,omitempty"`
+}
+
+// LoadInventory reads the inventory saved at path
+func LoadInventory(path string) (*Inventory, error) {
+	var inventory Inventory
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the inventory: %w", err)
+	}
+	err = json.Unmarshal(data, &inventory)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse the inventory %s: %v", ErrInvalidConfig, path, err)
+	}
+
+	return &inventory, nil
+}
+
+// Write saves the inventory at path, creating its directory if needed
+func (i *Inventory) Write(path string) error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create the inventory directory: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Select returns the clusters of the inventory matching the selectors, with the external IDs it knows of
+func (i *Inventory) Select(ctx context.Context, selectors, allowedSectors []string) ([]FleetCluster, error) {
+	clusters, err := SelectClusters(ctx, i.ManagementClusters, i.ServiceClusters, selectors, allowedSectors)
+	if err != nil {
+		return nil, err
+	}
+
+	for j := range clusters {
+		clusters[j].ExternalID = i.ExternalIDs[clusters[j].ClusterID]
+	}
+
+	return clusters, nil
+}
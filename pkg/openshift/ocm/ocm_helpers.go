@@ -1,19 +1,28 @@
 package ocm
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/MrSantamaria/acceptance_test/pkg/assets"
 	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
 	ocmsdk "github.com/openshift-online/ocm-sdk-go"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrInvalidConfig is returned when the token, environment or selectors given to the ocm package are not usable
+var ErrInvalidConfig = errors.New("invalid OCM configuration")
+
 var (
 	Ocm *ocmClient
 	env map[string]string = map[string]string{
@@ -54,154 +63,298 @@ type ClusterManagementReference struct {
 	Href      string `json:"href"`
 }
 
+// clustersMgmtCluster holds the fields we need from the clusters_mgmt cluster resource
+type clustersMgmtCluster struct {
+	ID         string `json:"id"`
+	ExternalID string `json:"external_id"`
+}
+
+// fleetPageSize is the number of clusters requested per page from the fleet manager
+const fleetPageSize = 100
+
+// maxFleetPages bounds the pages read from a fleet manager listing, far more than the fleet has,
+// so that a server ignoring the page parameter cannot keep the listing going forever
+const maxFleetPages = 100
+
+// FleetCluster is a management or service cluster matching the selectors
+type FleetCluster struct {
+	ClusterID  string
+	Kind       string
+	ExternalID string
+}
+
+const (
+	ManagementClusterKind = "ManagementCluster"
+	ServiceClusterKind    = "ServiceCluster"
+)
+
 // OCM is a wrapper around the OCM client.
 type ocmClient struct {
 	*ocmsdk.Connection
 }
 
-func CliCheck() bool {
-	cmd := exec.Command("ocm")
-	err := cmd.Run()
+func CliCheck(ctx context.Context) bool {
+	_, _, err := helpers.RunCommand(ctx, "ocm", nil)
 	if err == nil {
 		return true
 	}
 
-	fmt.Println("ocm cli is not configured.")
-	fmt.Println("This application requires the ocm cli to be installed for the backplane login to work.")
-	fmt.Println("https://github.com/openshift-online/ocm-cli")
+	logging.FromContext(ctx).Error("ocm cli is not configured. This application requires the ocm cli to be installed for the backplane login to work.",
+		"docs", "https://github.com/openshift-online/ocm-cli")
 
 	return false
 }
 
-func Login(token string, environment string) error {
+func Login(ctx context.Context, token string, environment string, ocmURL string) error {
 	// Check if the token is empty
 	if token == "" {
-		return fmt.Errorf("token cannot be empty")
+		return fmt.Errorf("%w: token cannot be empty", ErrInvalidConfig)
 	}
 
 	// Check if the specified environment is valid
 	if _, ok := env[environment]; !ok {
-		return fmt.Errorf("env %s is not a valid environment", environment)
+		return fmt.Errorf("%w: env %s is not a valid environment", ErrInvalidConfig, environment)
 	}
 
-	backplaneFile, err := helpers.CopyFileToCurrentDir(assets.Assets, backplaneConfig[environment])
-	if err != nil {
-		return err
+	// The runs with a configuration directory of their own, e.g. the runs of the server, already pass the backplane
+	// config of their environment to the ocm cli. The others share it with the whole process.
+	if _, ok := helpers.LookupCommandEnv(ctx, "BACKPLANE_CONFIG"); !ok {
+		backplaneFile, err := helpers.CopyFileToCurrentDir(assets.Assets, backplaneConfig[environment])
+		if err != nil {
+			return err
+		}
+
+		helpers.SetEnvVariables(fmt.Sprintf("BACKPLANE_CONFIG:%s", backplaneFile))
 	}
 
-	helpers.SetEnvVariables(fmt.Sprintf("BACKPLANE_CONFIG:%s", backplaneFile))
+	apiURL := APIURL(environment, ocmURL)
+
+	logging.FromContext(ctx).Info("logging in to OCM", "environment", environment, "url", apiURL)
+	ctx, span := tracing.Start(ctx, "ocm login", attribute.String("ocm.url", apiURL))
+	err := retry.Do(ctx, "ocm login", func(ctx context.Context) error {
+		_, stderr, err := helpers.RunCommand(ctx, "ocm", []string{"login", "--token", token, "--url", apiURL})
+		if err != nil {
+			return fmt.Errorf("error executing ocm login using token: %w\nStandard Error: %s", err, stderr)
+		}
+
+		return nil
+	})
+	tracing.End(span, err)
+
+	return err
+}
+
+// APIURL returns the OCM API URL of the environment, ocmURL overrides it to use a local stand-in of the OCM API
+func APIURL(environment, ocmURL string) string {
+	if ocmURL != "" {
+		return ocmURL
+	}
 
-	cmd := exec.Command("ocm", "login", "--token", token, "--url", env[environment])
+	return env[environment]
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// WriteBackplaneConfig writes the backplane config embedded for the environment to dir and returns its path,
+// which the ocm cli reads from BACKPLANE_CONFIG
+func WriteBackplaneConfig(dir, environment string) (string, error) {
+	name, ok := backplaneConfig[environment]
+	if !ok {
+		return "", fmt.Errorf("%w: env %s is not a valid environment", ErrInvalidConfig, environment)
+	}
 
-	fmt.Printf("Logging in to OCM for %s environment\n", environment)
-	err = cmd.Run()
+	data, err := assets.Assets.ReadFile(name)
 	if err != nil {
-		return fmt.Errorf("error executing ocm login using token: %v\nStandard Error: %s", err, stderr.String())
+		return "", fmt.Errorf("failed to read the backplane config %s: %w", name, err)
 	}
 
-	// If the command execution is successful, you can access the captured output as:
-	// output := stdout.String()
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write the backplane config: %w", err)
+	}
 
-	return nil
+	return path, nil
 }
 
-func GetManagementAndServiceClusterIDs() ([]string, error) {
-	var stdoutManagement, stderrManagement, stdoutService, stderrService bytes.Buffer
-	var clusterIDs []string
+// CheckBackplaneConfig checks the backplane config embedded for the environment is usable by the ocm login
+func CheckBackplaneConfig(environment string) (string, error) {
+	var config struct {
+		URL      string `json:"url"`
+		ProxyURL string `json:"proxy-url"`
+	}
 
-	// Command for management clusters
-	cmdManagement := exec.Command("ocm", "get", "/api/osd_fleet_mgmt/v1/management_clusters")
-	cmdManagement.Stdout = &stdoutManagement
-	cmdManagement.Stderr = &stderrManagement
+	name, ok := backplaneConfig[environment]
+	if !ok {
+		return "", fmt.Errorf("%w: env %s is not a valid environment", ErrInvalidConfig, environment)
+	}
 
-	fmt.Printf("Running command: %s\n", cmdManagement.Args)
-	err := cmdManagement.Run()
+	data, err := assets.Assets.ReadFile(name)
 	if err != nil {
-		return nil, fmt.Errorf("error executing ocm get management clusters: %v\nStandard Error: %s", err, stderrManagement.String())
+		return "", fmt.Errorf("failed to read the backplane config %s: %w", name, err)
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the backplane config %s: %v", name, err)
 	}
 
-	idsManagement, err := parseJsonDataForClusterIDs(stdoutManagement.String(), "ManagementCluster")
+	backplaneURL, err := url.Parse(config.URL)
+	if err != nil || backplaneURL.Scheme != "https" || backplaneURL.Host == "" {
+		return "", fmt.Errorf("backplane config %s has an invalid url %q", name, config.URL)
+	}
+	if config.ProxyURL != "" {
+		if proxyURL, err := url.Parse(config.ProxyURL); err != nil || proxyURL.Host == "" {
+			return "", fmt.Errorf("backplane config %s has an invalid proxy-url %q", name, config.ProxyURL)
+		}
+	}
+
+	return config.URL, nil
+}
+
+// GetManagementAndServiceClusters returns the management and service clusters matching the selectors,
+// the sector selector must be one of the allowed sectors when some are given
+func GetManagementAndServiceClusters(ctx context.Context, selectors, allowedSectors []string) ([]FleetCluster, error) {
+	managementClusters, serviceClusters, err := GetFleet(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	clusterIDs = append(clusterIDs, idsManagement...)
+	return SelectClusters(ctx, managementClusters, serviceClusters, selectors, allowedSectors)
+}
 
-	// Command for service clusters
-	cmdService := exec.Command("ocm", "get", "/api/osd_fleet_mgmt/v1/service_clusters")
-	cmdService.Stdout = &stdoutService
-	cmdService.Stderr = &stderrService
+// GetFleet returns the management and service cluster listings of the fleet manager
+func GetFleet(ctx context.Context) ([]Item, []Item, error) {
+	managementClusters, err := getFleetClusters(ctx, "/api/osd_fleet_mgmt/v1/management_clusters")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting management clusters: %w", err)
+	}
 
-	fmt.Printf("Running command: %s\n", cmdService.Args)
-	err = cmdService.Run()
+	serviceClusters, err := getFleetClusters(ctx, "/api/osd_fleet_mgmt/v1/service_clusters")
 	if err != nil {
-		return nil, fmt.Errorf("error executing ocm get service clusters: %v\nStandard Error: %s", err, stderrService.String())
+		return nil, nil, fmt.Errorf("error getting service clusters: %w", err)
 	}
 
-	idsService, err := parseJsonDataForClusterIDs(stdoutService.String(), "ServiceCluster")
+	return managementClusters, serviceClusters, nil
+}
+
+// SelectClusters returns the clusters of the management and service cluster listings matching the selectors.
+// It does not reach the fleet manager: the sectors the selectors are validated against are discovered in the listings.
+func SelectClusters(ctx context.Context, managementClusters, serviceClusters []Item, selectors, allowedSectors []string) ([]FleetCluster, error) {
+	var clusters []FleetCluster
+
+	sectors := discoverSectors(managementClusters, serviceClusters)
+
+	regionSelector, sectorSelector, err := createOCMSelectors(ctx, selectors, sectors, allowedSectors)
 	if err != nil {
 		return nil, err
 	}
 
-	clusterIDs = append(clusterIDs, idsService...)
+	clusters = append(clusters, filterClusters(managementClusters, ManagementClusterKind, regionSelector, sectorSelector)...)
+	clusters = append(clusters, filterClusters(serviceClusters, ServiceClusterKind, regionSelector, sectorSelector)...)
 
-	return clusterIDs, nil
+	return clusters, nil
 }
 
-func GetExternalIdFromClusterId(clusterIds []string) ([]string, error) {
-	// The stdout of the ocm describe cluster $id command does not output a json, we will use a regex to parse the output
-	var stdout, stderr bytes.Buffer
-	var clusterExternalIds []string
-	re := regexp.MustCompile(`External ID:\s+(\S+)`)
+// GetSectors returns the sectors present in the management and service cluster listings
+// along with the number of clusters in each of them.
+func GetSectors(ctx context.Context) (helpers.SectorSet, error) {
+	managementClusters, err := getFleetClusters(ctx, "/api/osd_fleet_mgmt/v1/management_clusters")
+	if err != nil {
+		return nil, fmt.Errorf("error getting management clusters: %w", err)
+	}
+
+	serviceClusters, err := getFleetClusters(ctx, "/api/osd_fleet_mgmt/v1/service_clusters")
+	if err != nil {
+		return nil, fmt.Errorf("error getting service clusters: %w", err)
+	}
+
+	return discoverSectors(managementClusters, serviceClusters), nil
+}
+
+// getFleetClusters lists every cluster of the given fleet manager endpoint, going through all the pages
+func getFleetClusters(ctx context.Context, path string) ([]Item, error) {
+	var items []Item
+	var previousFirstID string
+
+	for page := 1; page <= maxFleetPages; page++ {
+		parameters := url.Values{}
+		parameters.Set("page", strconv.Itoa(page))
+		parameters.Set("size", strconv.Itoa(fleetPageSize))
+
+		body, err := apiGet(ctx, path, parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		cluster, err := parseJsonData(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		// A server ignoring the page parameter serves the first page again
+		if len(cluster.Items) > 0 && cluster.Items[0].ID == previousFirstID {
+			logging.FromContext(ctx).Warn("the fleet manager served the same page twice, it does not page its listing", "path", path, "page", page)
+			return items, nil
+		}
+		if len(cluster.Items) > 0 {
+			previousFirstID = cluster.Items[0].ID
+		}
+		items = append(items, cluster.Items...)
+
+		// The server may cap the page size, the total tells when we have everything
+		if len(cluster.Items) == 0 || len(items) >= cluster.Total && (cluster.Total > 0 || len(cluster.Items) < fleetPageSize) {
+			return items, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error listing %s: still getting clusters after %d pages", path, maxFleetPages)
+}
+
+// GetExternalIdFromClusterId returns the external ID of every cluster, keyed by cluster ID.
+// Clusters whose external ID could not be found are left out.
+func GetExternalIdFromClusterId(ctx context.Context, clusterIds []string) (map[string]string, error) {
+	clusterExternalIds := map[string]string{}
 
 	for _, id := range clusterIds {
-		cmd := exec.Command("ocm", "describe", "cluster", id)
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
+		var cluster clustersMgmtCluster
 
-		fmt.Printf("Running command: %s\n", cmd.Args)
-		err := cmd.Run()
+		body, err := apiGet(ctx, "/api/clusters_mgmt/v1/clusters/"+id, nil)
 		if err != nil {
-			return clusterExternalIds, fmt.Errorf("error executing ocm describe cluster: %v\nStandard Error: %s", err, stderr.String())
+			return clusterExternalIds, fmt.Errorf("error getting cluster %s: %w", id, err)
 		}
 
-		match := re.FindStringSubmatch(stdout.String())
-		// Check if there is a line matching the regex
-		// If there is a match 'External ID' will be in match[0] and the external id will be in match[1]
-		if len(match) == 2 {
-			externalID := match[1]
-			clusterExternalIds = append(clusterExternalIds, externalID)
-		} else {
-			fmt.Println("External ID not found.")
+		err = json.Unmarshal(body, &cluster)
+		if err != nil {
+			return clusterExternalIds, fmt.Errorf("error parsing cluster %s: %w", id, err)
 		}
 
-		// Resets the buffer otherwise the next iteration will append to the previous stds
-		stdout.Reset()
-		stderr.Reset()
+		if cluster.ExternalID != "" {
+			externalID := cluster.ExternalID
+			clusterExternalIds[id] = externalID
+			logging.FromContext(ctx).Debug("found cluster external ID", "cluster_id", id, "external_id", externalID)
+		} else {
+			logging.FromContext(ctx).Warn("External ID not found", "cluster_id", id)
+		}
 	}
 
 	return clusterExternalIds, nil
 }
 
-func parseJsonDataForClusterIDs(jsonData, clusterKind string) ([]string, error) {
+// parseJsonData decodes a page of a fleet manager cluster listing
+func parseJsonData(jsonData string) (Cluster, error) {
 	var cluster Cluster
-	var clusterIDs []string
 
-	regionSelector, sectorSelector, err := createOCMSelectors(viper.GetStringSlice("selectors"))
+	err := json.NewDecoder(strings.NewReader(jsonData)).Decode(&cluster)
 	if err != nil {
-		return nil, err
+		return cluster, err
 	}
 
-	err = json.NewDecoder(strings.NewReader(jsonData)).Decode(&cluster)
-	if err != nil {
-		return nil, err
-	}
+	return cluster, nil
+}
+
+// filterClusters returns the clusters of the given kind in the region and sector of the selectors
+func filterClusters(items []Item, clusterKind, regionSelector, sectorSelector string) []FleetCluster {
+	var clusters []FleetCluster
 
-	for _, item := range cluster.Items {
+	for _, item := range items {
 		if item.Region != regionSelector {
 			continue
 		}
@@ -214,14 +367,27 @@ func parseJsonDataForClusterIDs(jsonData, clusterKind string) ([]string, error)
 			continue
 		}
 
-		clusterIDs = append(clusterIDs, item.ClusterManagementReference.ClusterID)
+		clusters = append(clusters, FleetCluster{ClusterID: item.ClusterManagementReference.ClusterID, Kind: item.Kind})
+	}
+
+	return clusters
+}
+
+// discoverSectors builds the set of sectors from the clusters returned by the fleet manager
+func discoverSectors(clusterLists ...[]Item) helpers.SectorSet {
+	sectors := helpers.SectorSet{}
+
+	for _, items := range clusterLists {
+		for _, item := range items {
+			sectors.Add(item.Sector)
+		}
 	}
 
-	return clusterIDs, nil
+	return sectors
 }
 
-// Create OCM selectors based on AWS regions and Openshift sectors
-func createOCMSelectors(selectors []string) (string, string, error) {
+// Create OCM selectors based on AWS regions and the Openshift sectors discovered in the fleet
+func createOCMSelectors(ctx context.Context, selectors []string, sectors helpers.SectorSet, allowedSectors []string) (string, string, error) {
 	var regionSelector, sectorSelector string
 
 	for _, selector := range selectors {
@@ -230,16 +396,20 @@ func createOCMSelectors(selectors []string) (string, string, error) {
 			regionSelector = trimmedSelector
 			continue
 		}
-		if helpers.IsOpenshiftSector(trimmedSelector) {
+		if helpers.IsOpenshiftSector(trimmedSelector, sectors, allowedSectors) {
 			sectorSelector = trimmedSelector
 			continue
 		}
 
-		fmt.Printf("Selector %s is not a valid AWS region or Openshift sector\nWe will ignore it for now. Reach out the SD-CICADA team if this shouldn't be the case\n", trimmedSelector)
+		logging.FromContext(ctx).Warn("Selector is not a valid AWS region or Openshift sector. We will ignore it for now. Reach out the SD-CICADA team if this shouldn't be the case",
+			"selector", trimmedSelector, "known_sectors", sectors.Names())
 	}
 
-	if regionSelector == "" || sectorSelector == "" {
-		return "", "", fmt.Errorf("error creating OCM selectors")
+	if regionSelector == "" {
+		return "", "", fmt.Errorf("%w: selectors %v do not include an AWS region", ErrInvalidConfig, selectors)
+	}
+	if sectorSelector == "" {
+		return "", "", fmt.Errorf("%w: selectors %v do not include a usable sector, known sectors are %v", ErrInvalidConfig, selectors, sectors.Names())
 	}
 
 	return regionSelector, sectorSelector, nil
@@ -0,0 +1,162 @@
+package ocm_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm/ocmfake"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+)
+
+func connect(t *testing.T, opts ocmfake.Options, token string) *ocmfake.Server {
+	t.Helper()
+
+	server, err := ocmfake.NewServer(opts)
+	if err != nil {
+		t.Fatalf("failed to start the fake OCM API: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	err = ocm.Connect(context.Background(), server.URL, token)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake OCM API: %v", err)
+	}
+	t.Cleanup(func() { ocm.Disconnect() })
+
+	return server
+}
+
+func TestGetSectorsPages(t *testing.T) {
+	server := connect(t, ocmfake.Options{PageSize: 1}, ocmfake.AccessToken())
+
+	sectors, err := ocm.GetSectors(context.Background())
+	if err != nil {
+		t.Fatalf("GetSectors() error = %v", err)
+	}
+
+	if sectors["main"] != 2 || sectors["canary"] != 1 {
+		t.Errorf("GetSectors() = %v, want main:2 canary:1", sectors)
+	}
+	if requests := server.Requests("/api/osd_fleet_mgmt/v1/management_clusters"); requests != 2 {
+		t.Errorf("management clusters were requested %d times, want one request per page", requests)
+	}
+}
+
+func TestGetSectorsUnpagedServer(t *testing.T) {
+	tests := []struct {
+		name string
+		// page returns the items of every page, which the server serves whatever page is asked for
+		page    func(request int) string
+		sectors map[string]int
+	}{
+		{
+			name: "same page",
+			page: func(request int) string {
+				return `{"id": "mc-1", "sector": "main", "region": "us-east-1"}`
+			},
+			sectors: map[string]int{"main": 2},
+		},
+		{
+			name: "endless pages",
+			page: func(request int) string {
+				items := make([]string, 100)
+				for i := range items {
+					items[i] = fmt.Sprintf(`{"id": "mc-%d-%d", "sector": "main", "region": "us-east-1"}`, request, i)
+				}
+				return strings.Join(items, ",")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"kind": "ClusterList", "page": 1, "items": [%s]}`, tt.page(requests))
+			}))
+			t.Cleanup(server.Close)
+
+			err := ocm.Connect(context.Background(), server.URL, ocmfake.AccessToken())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ocm.Disconnect() })
+
+			sectors, err := ocm.GetSectors(context.Background())
+			if tt.sectors == nil {
+				if err == nil {
+					t.Errorf("GetSectors() = %v, want an error once the pages run out", sectors)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetSectors() error = %v", err)
+			}
+			// Each of the management and service listings keeps its single cluster
+			if fmt.Sprint(sectors) != fmt.Sprint(tt.sectors) {
+				t.Errorf("GetSectors() = %v, want %v", sectors, tt.sectors)
+			}
+		})
+	}
+}
+
+func TestGetExternalIdFromClusterId(t *testing.T) {
+	connect(t, ocmfake.Options{}, ocmfake.AccessToken())
+
+	externalIDs, err := ocm.GetExternalIdFromClusterId(context.Background(), []string{"2a1b3c4d5e6f7g8h9i0j1k2l3m4n5o6p"})
+	if err != nil {
+		t.Fatalf("GetExternalIdFromClusterId() error = %v", err)
+	}
+
+	if got := externalIDs["2a1b3c4d5e6f7g8h9i0j1k2l3m4n5o6p"]; got != "6f1e5a7c-2b0d-4d63-9a43-0c7c3b1f2e01" {
+		t.Errorf("external ID = %q, want 6f1e5a7c-2b0d-4d63-9a43-0c7c3b1f2e01", got)
+	}
+
+	_, err = ocm.GetExternalIdFromClusterId(context.Background(), []string{"missing"})
+	if err == nil {
+		t.Error("GetExternalIdFromClusterId() of an unknown cluster succeeded")
+	}
+}
+
+func TestRetriesInjectedErrors(t *testing.T) {
+	server := connect(t, ocmfake.Options{}, ocmfake.AccessToken())
+	server.InjectError("/api/osd_fleet_mgmt/v1/service_clusters", http.StatusServiceUnavailable, 1)
+
+	policy := retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1}
+	recorder := &retry.Recorder{}
+	ctx := retry.WithRecorder(retry.WithPolicy(context.Background(), policy), recorder)
+
+	_, err := ocm.GetSectors(ctx)
+	if err != nil {
+		t.Fatalf("GetSectors() error = %v", err)
+	}
+	if attempts := recorder.Attempts(); len(attempts) != 1 || !attempts[0].Retryable {
+		t.Errorf("recorded attempts = %+v, want a single retryable attempt", attempts)
+	}
+
+	server.InjectError("/api/clusters_mgmt", http.StatusUnauthorized, -1)
+	_, err = ocm.GetExternalIdFromClusterId(ctx, []string{"2a1b3c4d5e6f7g8h9i0j1k2l3m4n5o6p"})
+	if err == nil {
+		t.Error("GetExternalIdFromClusterId() succeeded while the API returns 401")
+	}
+	if requests := server.Requests("/api/clusters_mgmt/v1/clusters/2a1b3c4d5e6f7g8h9i0j1k2l3m4n5o6p"); requests != 1 {
+		t.Errorf("cluster was requested %d times, want 1 as 401 is not retryable", requests)
+	}
+}
+
+func TestRejectsInvalidToken(t *testing.T) {
+	connect(t, ocmfake.Options{Token: "other-token"}, ocmfake.AccessToken())
+
+	_, err := ocm.GetSectors(context.Background())
+	if err == nil {
+		t.Error("GetSectors() succeeded with an invalid token")
+	}
+}
@@ -0,0 +1,312 @@
+// Package ocmfake serves the OCM fleet manager and clusters_mgmt endpoints used by the acceptance test from fixtures.
+// It is meant for tests and local development, it understands just enough of the OCM API for the ocm package.
+package ocmfake
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+//go:embed fixtures
+var defaultFixtures embed.FS
+
+const (
+	managementClustersPath = "/api/osd_fleet_mgmt/v1/management_clusters"
+	serviceClustersPath    = "/api/osd_fleet_mgmt/v1/service_clusters"
+	clustersPath           = "/api/clusters_mgmt/v1/clusters/"
+)
+
+// Options configures the fake OCM API
+type Options struct {
+	// FixturesDir is a directory laid out like the embedded fixtures: management_clusters.json,
+	// service_clusters.json and clusters/<id>.json. The embedded fixtures are used when it is empty.
+	FixturesDir string
+	// Token is the bearer token requests must carry, AccessToken() is used when it is empty.
+	// Clients built on the ocm-sdk only send JSON web tokens as they are, other tokens are exchanged against SSO first.
+	Token string
+	// PageSize is the page size used when the request does not ask for one and the largest page served, 100 by default
+	PageSize int
+	// Latency is added to every response
+	Latency time.Duration
+}
+
+// injectedError is a status returned instead of the fixture for the requests matching a path prefix
+type injectedError struct {
+	pathPrefix string
+	status     int
+	remaining  int
+}
+
+// Handler is the http.Handler of the fake OCM API
+type Handler struct {
+	fixtures fs.FS
+	token    string
+	pageSize int
+
+	mu       sync.Mutex
+	latency  time.Duration
+	errors   []*injectedError
+	requests map[string]int
+}
+
+// Server is a fake OCM API listening on a local address, like httptest.Server
+type Server struct {
+	*httptest.Server
+	*Handler
+}
+
+// AccessToken returns an unsigned access token without expiration.
+// The ocm-sdk and the ocm cli use it as is, without trying to refresh it against SSO.
+func AccessToken() string {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	return encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		encode([]byte(`{"typ":"Bearer","sub":"acceptance-test","iss":"ocmfake"}`)) + "."
+}
+
+// NewHandler returns the handler of a fake OCM API serving the fixtures of opts
+func NewHandler(opts Options) (*Handler, error) {
+	h := &Handler{
+		token:    opts.Token,
+		pageSize: opts.PageSize,
+		latency:  opts.Latency,
+		requests: map[string]int{},
+	}
+
+	if h.token == "" {
+		h.token = AccessToken()
+	}
+	if h.pageSize <= 0 {
+		h.pageSize = 100
+	}
+
+	if opts.FixturesDir != "" {
+		if _, err := os.Stat(opts.FixturesDir); err != nil {
+			return nil, fmt.Errorf("invalid fixtures directory: %w", err)
+		}
+		h.fixtures = os.DirFS(opts.FixturesDir)
+	} else {
+		fixtures, err := fs.Sub(defaultFixtures, "fixtures")
+		if err != nil {
+			return nil, err
+		}
+		h.fixtures = fixtures
+	}
+
+	return h, nil
+}
+
+// NewServer starts a fake OCM API on a local port, it must be closed by the caller
+func NewServer(opts Options) (*Server, error) {
+	h, err := NewHandler(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{Server: httptest.NewServer(h), Handler: h}, nil
+}
+
+// Token returns the bearer token the requests must carry
+func (h *Handler) Token() string {
+	return h.token
+}
+
+// InjectError makes the next times requests whose path starts with pathPrefix fail with status.
+// A negative times makes them fail until the error is cleared with ClearErrors.
+func (h *Handler) InjectError(pathPrefix string, status, times int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = append(h.errors, &injectedError{pathPrefix: pathPrefix, status: status, remaining: times})
+}
+
+// ClearErrors removes the errors injected with InjectError
+func (h *Handler) ClearErrors() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = nil
+}
+
+// SetLatency changes the delay added to every response
+func (h *Handler) SetLatency(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latency = latency
+}
+
+// Requests returns the number of requests received for path
+func (h *Handler) Requests(path string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.requests[path]
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	latency, injected := h.record(r.URL.Path)
+	logger := slog.With("component", "ocmfake", "method", r.Method, "path", r.URL.Path)
+	logger.Debug("request received")
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+h.token {
+		writeError(w, http.StatusUnauthorized, "401", "Invalid access token")
+		return
+	}
+
+	if injected != 0 {
+		logger.Debug("returning injected error", "status", injected)
+		writeError(w, injected, strconv.Itoa(injected), "Injected error")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "405", "Method "+r.Method+" is not supported")
+		return
+	}
+
+	switch {
+	case r.URL.Path == managementClustersPath:
+		h.serveList(w, r, "management_clusters.json")
+	case r.URL.Path == serviceClustersPath:
+		h.serveList(w, r, "service_clusters.json")
+	case strings.HasPrefix(r.URL.Path, clustersPath) && !strings.Contains(strings.TrimPrefix(r.URL.Path, clustersPath), "/"):
+		h.serveCluster(w, strings.TrimPrefix(r.URL.Path, clustersPath))
+	default:
+		writeError(w, http.StatusNotFound, "404", "Path "+r.URL.Path+" is not served by the fake OCM API")
+	}
+}
+
+// record counts the request and returns the latency to apply and the injected status, zero if there is none
+func (h *Handler) record(requestPath string) (time.Duration, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests[requestPath]++
+
+	for _, injected := range h.errors {
+		if !strings.HasPrefix(requestPath, injected.pathPrefix) || injected.remaining == 0 {
+			continue
+		}
+		if injected.remaining > 0 {
+			injected.remaining--
+		}
+		return h.latency, injected.status
+	}
+
+	return h.latency, 0
+}
+
+// serveList returns a page of the items of the list fixture, following the page and size parameters of OCM
+func (h *Handler) serveList(w http.ResponseWriter, r *http.Request, fixture string) {
+	var list struct {
+		Kind  string            `json:"kind"`
+		Items []json.RawMessage `json:"items"`
+	}
+
+	data, err := fs.ReadFile(h.fixtures, fixture)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "500", "Can't read fixture "+fixture)
+		return
+	}
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "500", "Can't parse fixture "+fixture)
+		return
+	}
+
+	page, err := intParameter(r, "page", 1)
+	if err != nil || page < 1 {
+		writeError(w, http.StatusBadRequest, "400", "Value of 'page' parameter is not a positive integer")
+		return
+	}
+	size, err := intParameter(r, "size", h.pageSize)
+	if err != nil || size < 0 {
+		writeError(w, http.StatusBadRequest, "400", "Value of 'size' parameter is not a positive integer")
+		return
+	}
+	if size > h.pageSize {
+		size = h.pageSize
+	}
+
+	start := (page - 1) * size
+	end := start + size
+	if start > len(list.Items) {
+		start = len(list.Items)
+	}
+	if end > len(list.Items) {
+		end = len(list.Items)
+	}
+	items := list.Items[start:end]
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":  list.Kind,
+		"page":  page,
+		"size":  len(items),
+		"total": len(list.Items),
+		"items": items,
+	})
+}
+
+// serveCluster returns the clusters/<id>.json fixture
+func (h *Handler) serveCluster(w http.ResponseWriter, id string) {
+	data, err := fs.ReadFile(h.fixtures, path.Join("clusters", id+".json"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "404", "Cluster '"+id+"' not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func intParameter(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// writeError writes an error in the format of the OCM API
+func writeError(w http.ResponseWriter, status int, code, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"kind":   "Error",
+		"id":     code,
+		"href":   "/api/clusters_mgmt/v1/errors/" + code,
+		"code":   "OCMFAKE-" + code,
+		"reason": reason,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
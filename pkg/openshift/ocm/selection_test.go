@@ -0,0 +1,101 @@
+package ocm
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the selection tests")
+
+// selectionCase is the selection.json file of a test case
+type selectionCase struct {
+	Selectors      []string `json:"selectors"`
+	AllowedSectors []string `json:"allowed_sectors"`
+}
+
+// selectionGolden is the golden.json file of a test case
+type selectionGolden struct {
+	Sectors  helpers.SectorSet `json:"sectors"`
+	Clusters []FleetCluster    `json:"clusters"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// TestSelectClusters runs the cluster selection over every case of testdata/selection.
+// A case holds the fleet manager listings, the selectors and the expected result in golden.json,
+// run go test ./pkg/openshift/ocm -run TestSelectClusters -update to regenerate the golden files.
+func TestSelectClusters(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "selection", "*"))
+	if err != nil || len(cases) == 0 {
+		t.Fatalf("no selection test cases found: %v", err)
+	}
+
+	for _, dir := range cases {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			var selection selectionCase
+			readJSON(t, filepath.Join(dir, "selection.json"), &selection)
+
+			managementClusters := readListing(t, filepath.Join(dir, "management_clusters.json"))
+			serviceClusters := readListing(t, filepath.Join(dir, "service_clusters.json"))
+
+			got := selectionGolden{Sectors: discoverSectors(managementClusters, serviceClusters)}
+			got.Clusters, err = SelectClusters(context.Background(), managementClusters, serviceClusters, selection.Selectors, selection.AllowedSectors)
+			if err != nil {
+				got.Error = err.Error()
+			}
+
+			goldenPath := filepath.Join(dir, "golden.json")
+			if *update {
+				data, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(goldenPath, append(data, '\n'), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var want selectionGolden
+			readJSON(t, goldenPath, &want)
+			if !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				t.Errorf("selection does not match %s, got:\n%s", goldenPath, gotJSON)
+			}
+		})
+	}
+}
+
+func readListing(t *testing.T, path string) []Item {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing, err := parseJsonData(string(data))
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+
+	return listing.Items
+}
+
+func readJSON(t *testing.T, path string, value interface{}) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+}
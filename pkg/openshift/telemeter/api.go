@@ -0,0 +1,129 @@
+package telemeter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+)
+
+// metricsClient queries the Prometheus API of an Observatorium tenant over HTTP
+type metricsClient struct {
+	apiURL string
+	tenant string
+	token  string
+	http   *http.Client
+}
+
+// Telemeter is set by Connect, the queries go through the obsctl cli when it is nil
+var Telemeter *metricsClient
+
+// Connect makes the telemeter package send its queries to the Observatorium API at apiURL instead of the obsctl cli.
+// It is meant for talking to a local stand-in of Observatorium, the acceptance test itself relies on obsctl.
+func Connect(ctx context.Context, apiURL, tenant, token string) error {
+	if apiURL == "" || tenant == "" || token == "" {
+		return fmt.Errorf("%w: the API URL, tenant and token are required to connect", ErrInvalidConfig)
+	}
+
+	Telemeter = &metricsClient{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		tenant: tenant,
+		token:  token,
+		http:   &http.Client{},
+	}
+
+	return nil
+}
+
+// Disconnect forgets the connection opened by Connect, the obsctl cli is used again afterwards
+func Disconnect() {
+	Telemeter = nil
+}
+
+// OidcToken requests an access token for the client credentials from the OIDC provider at issuerURL,
+// the same way obsctl login does.
+func OidcToken(ctx context.Context, issuerURL, audience, clientID, clientSecret string) (string, error) {
+	var discovery struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	body, err := send(ctx, http.DefaultClient, http.MethodGet, strings.TrimSuffix(issuerURL, "/")+"/.well-known/openid-configuration", nil, "")
+	if err != nil {
+		return "", fmt.Errorf("error discovering the OIDC provider: %w", err)
+	}
+	err = json.Unmarshal(body, &discovery)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the OIDC provider configuration: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+
+	body, err = send(ctx, http.DefaultClient, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()), "")
+	if err != nil {
+		return "", fmt.Errorf("error requesting an OIDC token: %w", err)
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the OIDC token: %v", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// query runs an instant query against the tenant and returns the raw Prometheus API response
+func (c *metricsClient) query(ctx context.Context, searchQuery string) ([]byte, error) {
+	endpoint := c.apiURL + "/api/metrics/v1/" + c.tenant + "/api/v1/query?" + url.Values{"query": {searchQuery}}.Encode()
+
+	return send(ctx, c.http, http.MethodGet, endpoint, nil, c.token)
+}
+
+// send runs an HTTP request bounded by the operation timeout stored in ctx.
+// The error message of failed requests carries the status like the cli errors so that the retryable ones are recognised.
+func send(ctx context.Context, client *http.Client, method, endpoint string, body io.Reader, token string) ([]byte, error) {
+	if timeout := helpers.OperationTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	if method == http.MethodPost {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s %s: status is %d: %s", method, request.URL.Path, response.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
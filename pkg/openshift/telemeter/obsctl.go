@@ -1,13 +1,24 @@
 package telemeter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-
-	"github.com/spf13/viper"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
 )
 
 type obsctlConfig struct {
@@ -38,10 +49,14 @@ type obsctlSearchResult struct {
 				Name string `json:"name"`
 			} `json:"metric"`
 			Values [][]interface{} `json:"values"`
+			Value  []interface{}   `json:"value"`
 		} `json:"result"`
 	} `json:"data"`
 }
 
+// ErrInvalidConfig is returned when the Telemeter credentials are missing
+var ErrInvalidConfig = errors.New("invalid Telemeter configuration")
+
 var (
 	config = environmentConfig{
 		intStage: obsctlConfig{
@@ -53,7 +68,6 @@ var (
 			OidcIssuerURL:     "https://sso.redhat.com/auth/realms/redhat-external",
 			OidcOfflineAccess: "false",
 			Tenant:            "telemeter",
-			LogLevel:          "debug",
 		},
 		// TODO: Update this to production once we have a production environment
 		prod: obsctlConfig{
@@ -65,19 +79,17 @@ var (
 			OidcIssuerURL:     "https://sso.redhat.com/auth/realms/redhat-external",
 			OidcOfflineAccess: "false",
 			Tenant:            "telemeter",
-			LogLevel:          "debug",
 		},
 	}
 )
 
-func CliCheck() bool {
-	cmd := exec.Command("obsctl")
-	err := cmd.Run()
+func CliCheck(ctx context.Context) bool {
+	_, _, err := helpers.RunCommand(ctx, "obsctl", nil)
 	if err == nil {
 		return true
 	}
 
-	fmt.Println("obscli is not configured.")
+	logging.FromContext(ctx).Error("obsctl cli is not configured.", "docs", "https://github.com/observatorium/obsctl")
 
 	return false
 }
@@ -91,103 +103,118 @@ func SetObsctlConfig(environment string) obsctlConfig {
 	case "prod":
 		return config.prod
 	default:
-		fmt.Println("ERROR: Invalid environment. Please use int, stage, or prod.")
+		slog.Error("Invalid environment. Please use int, stage, or prod.", "environment", environment)
 		return obsctlConfig{}
 	}
 }
 
-func addObsctlContext(telemeterConfig *obsctlConfig) error {
-	var ErrSkipContextCreation = fmt.Errorf("Context already exists. Skipping context creation.")
-	cmd := exec.Command("obsctl", "context", "api", "add", "--name="+telemeterConfig.ContextName, "--url="+telemeterConfig.ContextApi)
-
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
-	if errors.As(err, &ErrSkipContextCreation) {
-		fmt.Println("Context already exists. Skipping context creation.")
+func addObsctlContext(ctx context.Context, telemeterConfig *obsctlConfig) error {
+	_, stderr, err := helpers.RunCommand(ctx, "obsctl", []string{"context", "api", "add", "--name=" + telemeterConfig.ContextName, "--url=" + telemeterConfig.ContextApi})
+	if err != nil && contextExists(stderr) {
+		logging.FromContext(ctx).Info("Context already exists. Skipping context creation.", "context", telemeterConfig.ContextName)
 		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("error running obsctl context command: %v", err)
+		return fmt.Errorf("error running obsctl context command: %w\nStandard Error: %s", err, stderr)
 	}
 
 	return nil
 }
 
-func updateObsctlConfig(telemeterConfig *obsctlConfig) error {
-	telemeterConfig.OidcClientID = viper.GetString("TELEMETER_CLIENT_ID")
+// contextExists tells whether obsctl context api add failed because the context was added by a previous run.
+// obsctl only says so on its standard error, its exit status is the one of any other failure.
+func contextExists(stderr []byte) bool {
+	return strings.Contains(string(stderr), "already exists")
+}
+
+func updateObsctlConfig(telemeterConfig *obsctlConfig, clientID, clientSecret string) error {
+	telemeterConfig.OidcClientID = clientID
 	if len(telemeterConfig.OidcClientID) == 0 {
-		return fmt.Errorf("TELEMETER_CLIENT_ID is required")
+		return fmt.Errorf("%w: TELEMETER_CLIENT_ID is required", ErrInvalidConfig)
 	}
-	telemeterConfig.OidcClientSecret = viper.GetString("TELEMETER_SECRET")
+	telemeterConfig.OidcClientSecret = clientSecret
 	if len(telemeterConfig.OidcClientSecret) == 0 {
-		return fmt.Errorf("TELEMETER_SECRET is required")
+		return fmt.Errorf("%w: TELEMETER_SECRET is required", ErrInvalidConfig)
 	}
 
 	return nil
 }
 
-func ObsctlLogin(telemeterConfig obsctlConfig) error {
-	err := updateObsctlConfig(&telemeterConfig)
+func ObsctlLogin(ctx context.Context, telemeterConfig obsctlConfig, clientID, clientSecret string) error {
+	err := updateObsctlConfig(&telemeterConfig, clientID, clientSecret)
 	if err != nil {
-		return fmt.Errorf("error updating obsctl config: %v", err)
+		return fmt.Errorf("error updating obsctl config: %w", err)
 	}
-	err = addObsctlContext(&telemeterConfig)
+	err = addObsctlContext(ctx, &telemeterConfig)
 	if err != nil {
-		return fmt.Errorf("error adding obsctl context: %v", err)
-	}
-
-	cmd := exec.Command("obsctl", "login",
-		"--api="+telemeterConfig.ContextName,
-		"--oidc.audience="+telemeterConfig.OidcAudience,
-		"--oidc.client-id="+telemeterConfig.OidcClientID,
-		"--oidc.client-secret="+telemeterConfig.OidcClientSecret,
-		"--oidc.issuer-url="+telemeterConfig.OidcIssuerURL,
-		"--oidc.offline-access="+telemeterConfig.OidcOfflineAccess,
-		"--tenant="+telemeterConfig.Tenant,
-		"--log.level="+telemeterConfig.LogLevel,
-	)
-
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	fmt.Printf("Running command: %s\n", cmd.Args)
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("error running obsctl login command: %v", err)
+		return fmt.Errorf("error adding obsctl context: %w", err)
+	}
+	telemeterConfig.LogLevel = logging.LevelName()
+
+	args := []string{"login",
+		"--api=" + telemeterConfig.ContextName,
+		"--oidc.audience=" + telemeterConfig.OidcAudience,
+		"--oidc.client-id=" + telemeterConfig.OidcClientID,
+		"--oidc.client-secret=" + telemeterConfig.OidcClientSecret,
+		"--oidc.issuer-url=" + telemeterConfig.OidcIssuerURL,
+		"--oidc.offline-access=" + telemeterConfig.OidcOfflineAccess,
+		"--tenant=" + telemeterConfig.Tenant,
+		"--log.level=" + telemeterConfig.LogLevel,
 	}
 
-	return nil
-}
+	logging.FromContext(ctx).Info("logging in to Telemeter", "context", telemeterConfig.ContextName)
+	ctx, span := tracing.Start(ctx, "obsctl login", attribute.String("telemeter.context", telemeterConfig.ContextName))
+	err = retry.Do(ctx, "obsctl login", func(ctx context.Context) error {
+		_, stderr, err := helpers.RunCommand(ctx, "obsctl", args)
+		if err != nil {
+			return fmt.Errorf("error running obsctl login command: %w\nStandard Error: %s", err, stderr)
+		}
 
-func ObsctlSetContext(searchQuery string) error {
-	cmd := exec.Command("obsctl", "")
+		return nil
+	})
+	tracing.End(span, err)
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return err
+}
 
-	fmt.Printf("Running command: %s\n", cmd.Args)
-	err := cmd.Run()
+func ObsctlSetContext(ctx context.Context, searchQuery string) error {
+	_, stderr, err := helpers.RunCommand(ctx, "obsctl", []string{""})
 	if err != nil {
-		return fmt.Errorf("error running obsctl context command: %v", err)
+		return fmt.Errorf("error running obsctl context command: %w\nStandard Error: %s", err, stderr)
 	}
 
 	return nil
 }
 
-func ObsctlSearchQuery(searchQuery string) (obsctlSearchResult, error) {
+func ObsctlSearchQuery(ctx context.Context, searchQuery string) (obsctlSearchResult, error) {
 	var obsctlSearchResult obsctlSearchResult
-	cmd := exec.Command("obsctl", "metrics", "query", searchQuery)
 
-	fmt.Printf("Running command: %s\n", cmd.Args)
+	var output []byte
+
+	ctx, span := tracing.Start(ctx, "telemeter query", attribute.String("telemeter.query", searchQuery))
+	err := retry.Do(ctx, "obsctl metrics query", func(ctx context.Context) error {
+		var err error
+
+		start := time.Now()
+		output, err = replay.Do(ctx, "telemeter", searchQuery, func(ctx context.Context) ([]byte, error) {
+			if Telemeter != nil {
+				return Telemeter.query(ctx, searchQuery)
+			}
+
+			stdout, stderr, err := helpers.RunCommand(ctx, "obsctl", []string{"metrics", "query", searchQuery}, "query", searchQuery)
+			if err != nil {
+				return nil, fmt.Errorf("error running obsctl metrics query command: %w\nStandard Error: %s", err, stderr)
+			}
+
+			return stdout, nil
+		})
+		metrics.From(ctx).ObserveQuery("telemeter", start, err)
 
-	output, err := cmd.CombinedOutput()
+		return err
+	})
+	tracing.End(span, err)
 	if err != nil {
-		return obsctlSearchResult, fmt.Errorf("error running obsctl metrics query command: %v", err)
+		return obsctlSearchResult, err
 	}
 
 	err = json.Unmarshal(output, &obsctlSearchResult)
@@ -214,21 +241,104 @@ func ObsctlProccessSearchResult(searchResult obsctlSearchResult) int {
 	return csvCount
 }
 
-func ObsctlLogout(telemeterConfig obsctlConfig) error {
-	cmd := exec.Command("obsctl", "logout",
-		"--api="+telemeterConfig.ContextName,
-		"--tenant="+telemeterConfig.Tenant,
-	)
+// ObsctlSeriesCount returns the number of series returned by a query, whatever their metric name
+func ObsctlSeriesCount(searchResult obsctlSearchResult) int {
+	return len(searchResult.Data.Result)
+}
+
+// finite reports whether a sample value is a number, Prometheus returns NaN and infinite values, e.g. for the rate
+// over an empty window, which have no JSON representation and would break the report
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
 
-	// Set the command's standard input, output, and error streams to be the same as the current process
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// ObsctlSumLatestValues adds up the latest sample of every series returned by a query, a series whose latest sample
+// is not finite adds nothing.
+// Range queries return a list of samples per series while instant queries return a single one.
+func ObsctlSumLatestValues(searchResult obsctlSearchResult) (float64, error) {
+	var sum float64
+
+	for _, result := range searchResult.Data.Result {
+		sample := result.Value
+		if len(result.Values) > 0 {
+			sample = result.Values[len(result.Values)-1]
+		}
+		if len(sample) != 2 {
+			continue
+		}
+
+		value, ok := sample[1].(string)
+		if !ok {
+			return 0, fmt.Errorf("unexpected sample value %v", sample[1])
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing sample value %q: %v", value, err)
+		}
+		if !finite(parsed) {
+			continue
+		}
+		sum += parsed
+	}
+
+	return sum, nil
+}
+
+// ObsctlSeries returns the finite samples of every series returned by a query, named after its metric and name labels.
+// Range queries return a list of samples per series while instant queries return a single one.
+func ObsctlSeries(searchResult obsctlSearchResult) ([]report.Series, error) {
+	var series []report.Series
+
+	for _, result := range searchResult.Data.Result {
+		samples := result.Values
+		if len(samples) == 0 && len(result.Value) > 0 {
+			samples = [][]interface{}{result.Value}
+		}
+
+		name := result.Metric.CSV
+		if result.Metric.Name != "" {
+			name += "{name=" + strconv.Quote(result.Metric.Name) + "}"
+		}
+		s := report.Series{Name: name}
+		for _, sample := range samples {
+			if len(sample) != 2 {
+				continue
+			}
+
+			timestamp, ok := sample[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("unexpected sample time %v", sample[0])
+			}
+			value, ok := sample[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected sample value %v", sample[1])
+			}
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing sample value %q: %v", value, err)
+			}
+			if !finite(parsed) {
+				continue
+			}
+
+			s.Samples = append(s.Samples, report.Sample{Time: time.UnixMilli(int64(timestamp * 1000)).UTC(), Value: parsed})
+		}
+		series = append(series, s)
+	}
+
+	return series, nil
+}
+
+func ObsctlLogout(ctx context.Context, telemeterConfig obsctlConfig) error {
+	args := []string{"logout",
+		"--api=" + telemeterConfig.ContextName,
+		"--tenant=" + telemeterConfig.Tenant,
+	}
 
-	fmt.Printf("Running command: %s\n", cmd.Args)
-	err := cmd.Run()
+	logging.FromContext(ctx).Info("logging out of Telemeter", "context", telemeterConfig.ContextName)
+	_, stderr, err := helpers.RunCommand(ctx, "obsctl", args)
 	if err != nil {
-		return fmt.Errorf("error running obsctl logout command: %v", err)
+		return fmt.Errorf("error running obsctl logout command: %w\nStandard Error: %s", err, stderr)
 	}
 
 	return nil
@@ -0,0 +1,57 @@
+package telemeter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+)
+
+func TestContextExists(t *testing.T) {
+	for _, tc := range []struct {
+		stderr string
+		want   bool
+	}{
+		{`Error: context "staging-api-x" already exists`, true},
+		{"Error: failed to parse the URL of the API", false},
+		{"", false},
+	} {
+		if got := contextExists([]byte(tc.stderr)); got != tc.want {
+			t.Errorf("contextExists(%q) = %v, want %v", tc.stderr, got, tc.want)
+		}
+	}
+}
+
+// nanResult is a range query result whose series are partly or only NaN and infinite, as rate over an empty window returns
+const nanResult = `{"status": "success", "data": {"resultType": "matrix", "result": [
+	{"metric": {"__name__": "restarts"}, "values": [[1700000000, "2"], [1700000060, "NaN"], [1700000120, "3"]]},
+	{"metric": {"__name__": "restarts", "name": "silent"}, "values": [[1700000000, "+Inf"], [1700000060, "NaN"]]},
+	{"metric": {"__name__": "restarts", "name": "negative"}, "values": [[1700000000, "-Inf"]]}
+]}}`
+
+func TestObsctlNaNSamples(t *testing.T) {
+	var result obsctlSearchResult
+	err := json.Unmarshal([]byte(nanResult), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := ObsctlSeries(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 3 || len(series[0].Samples) != 2 || len(series[1].Samples) != 0 || len(series[2].Samples) != 0 {
+		t.Errorf("series = %+v, want the NaN and infinite samples dropped", series)
+	}
+
+	sum, err := ObsctlSumLatestValues(result)
+	if err != nil || sum != 3 {
+		t.Errorf("ObsctlSumLatestValues() = %v, %v, want 3", sum, err)
+	}
+
+	// The samples end up in the JSON report and the run history
+	_, err = json.Marshal(report.Comparison{Current: sum, CurrentSamples: series})
+	if err != nil {
+		t.Errorf("the samples cannot be marshalled: %v", err)
+	}
+}
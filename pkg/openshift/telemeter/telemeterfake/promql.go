@@ -0,0 +1,466 @@
+package telemeterfake
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// The fake understands the subset of PromQL used by the acceptance test queries:
+// vector selectors with label matchers, range selectors, offset modifiers,
+// the increase and rate functions and the sum, count, min, max and avg aggregations without grouping.
+// Unlike Prometheus, increase and rate do not extrapolate to the edges of the range.
+
+// lookbackDelta is how far back an instant vector selector looks for a sample, as in Prometheus
+const lookbackDelta = 5 * time.Minute
+
+type expr interface{}
+
+type matcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+type selectorExpr struct {
+	matchers []matcher
+	rng      time.Duration
+	offset   time.Duration
+}
+
+type callExpr struct {
+	fn  string
+	arg expr
+}
+
+var (
+	rangeFunctions = map[string]bool{"increase": true, "rate": true}
+	aggregations   = map[string]bool{"sum": true, "count": true, "min": true, "max": true, "avg": true}
+)
+
+// point is a sample of a series at a time
+type point struct {
+	t time.Time
+	v float64
+}
+
+// vectorSample is an element of an instant vector
+type vectorSample struct {
+	labels map[string]string
+	point  point
+}
+
+// matrixSeries is an element of a range vector
+type matrixSeries struct {
+	labels map[string]string
+	points []point
+}
+
+// parser is a recursive descent parser over the query string
+type parser struct {
+	input string
+	pos   int
+}
+
+func parseQuery(query string) (expr, error) {
+	p := &parser{input: query}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+
+	return e, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	p.skipSpaces()
+
+	if p.peek() == '{' {
+		return p.parseSelector("")
+	}
+
+	name := p.identifier()
+	if name == "" {
+		return nil, fmt.Errorf("expected a metric name or a function at position %d", p.pos)
+	}
+
+	p.skipSpaces()
+	if p.peek() != '(' {
+		return p.parseSelector(name)
+	}
+	if !rangeFunctions[name] && !aggregations[name] {
+		return nil, fmt.Errorf("function %q is not supported by the fake", name)
+	}
+
+	p.pos++
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	if rangeFunctions[name] {
+		selector, ok := arg.(*selectorExpr)
+		if !ok || selector.rng == 0 {
+			return nil, fmt.Errorf("%s expects a range vector", name)
+		}
+	}
+
+	return &callExpr{fn: name, arg: arg}, nil
+}
+
+func (p *parser) parseSelector(name string) (expr, error) {
+	selector := &selectorExpr{}
+	if name != "" {
+		selector.matchers = append(selector.matchers, matcher{name: model.MetricNameLabel, op: "=", value: name})
+	}
+
+	p.skipSpaces()
+	if p.peek() == '{' {
+		p.pos++
+		for {
+			p.skipSpaces()
+			if p.peek() == '}' {
+				p.pos++
+				break
+			}
+
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			selector.matchers = append(selector.matchers, m)
+
+			p.skipSpaces()
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+	}
+	if len(selector.matchers) == 0 {
+		return nil, fmt.Errorf("selector at position %d has no matchers", p.pos)
+	}
+
+	p.skipSpaces()
+	if p.peek() == '[' {
+		p.pos++
+		end := strings.IndexByte(p.input[p.pos:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated range at position %d", p.pos)
+		}
+		rng, err := model.ParseDuration(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+		if err != nil {
+			return nil, err
+		}
+		selector.rng = time.Duration(rng)
+		p.pos += end + 1
+	}
+
+	p.skipSpaces()
+	if strings.HasPrefix(p.input[p.pos:], "offset") {
+		p.pos += len("offset")
+		p.skipSpaces()
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte(" \t\n)", p.input[p.pos]) < 0 {
+			p.pos++
+		}
+		offset, err := model.ParseDuration(p.input[start:p.pos])
+		if err != nil {
+			return nil, err
+		}
+		selector.offset = time.Duration(offset)
+	}
+
+	return selector, nil
+}
+
+func (p *parser) parseMatcher() (matcher, error) {
+	m := matcher{name: p.identifier()}
+	if m.name == "" {
+		return m, fmt.Errorf("expected a label name at position %d", p.pos)
+	}
+
+	p.skipSpaces()
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			m.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if m.op == "" {
+		return m, fmt.Errorf("expected a matcher operator at position %d", p.pos)
+	}
+
+	p.skipSpaces()
+	if p.peek() != '"' {
+		return m, fmt.Errorf("expected a quoted label value at position %d", p.pos)
+	}
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != '"' {
+		if p.input[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.input) {
+		return m, fmt.Errorf("unterminated label value at position %d", p.pos)
+	}
+	value, err := strconv.Unquote(p.input[p.pos : end+1])
+	if err != nil {
+		return m, fmt.Errorf("invalid label value at position %d: %v", p.pos, err)
+	}
+	m.value = value
+	p.pos = end + 1
+
+	if m.op == "=~" || m.op == "!~" {
+		// Prometheus regular expressions are fully anchored
+		m.re, err = regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+func (p *parser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || p.pos > start && c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+
+	return p.input[start:p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpaces()
+	if p.peek() != c {
+		return fmt.Errorf("expected %q at position %d", c, p.pos)
+	}
+	p.pos++
+
+	return nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (m matcher) matches(labels map[string]string) bool {
+	value := labels[m.name]
+
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// evaluate evaluates e at time t over the series, it returns either a []vectorSample or a []matrixSeries
+func evaluate(e expr, series []Series, t time.Time) (interface{}, error) {
+	switch e := e.(type) {
+	case *selectorExpr:
+		if e.rng > 0 {
+			return selectRange(e, series, t), nil
+		}
+		return selectInstant(e, series, t), nil
+
+	case *callExpr:
+		arg, err := evaluate(e.arg, series, t)
+		if err != nil {
+			return nil, err
+		}
+
+		if rangeFunctions[e.fn] {
+			return applyRangeFunction(e.fn, e.arg.(*selectorExpr).rng, arg.([]matrixSeries), t), nil
+		}
+
+		vector, ok := arg.([]vectorSample)
+		if !ok {
+			return nil, fmt.Errorf("%s expects an instant vector", e.fn)
+		}
+		return aggregate(e.fn, vector, t), nil
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+func selectedSeries(selector *selectorExpr, series []Series) []Series {
+	var selected []Series
+
+	for _, s := range series {
+		matches := true
+		for _, m := range selector.matchers {
+			if !m.matches(s.Labels) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			selected = append(selected, s)
+		}
+	}
+
+	return selected
+}
+
+func selectRange(selector *selectorExpr, series []Series, t time.Time) []matrixSeries {
+	var matrix []matrixSeries
+	end := t.Add(-selector.offset)
+	start := end.Add(-selector.rng)
+
+	for _, s := range selectedSeries(selector, series) {
+		var points []point
+		for _, p := range s.points {
+			if p.t.After(start) && !p.t.After(end) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			matrix = append(matrix, matrixSeries{labels: s.Labels, points: points})
+		}
+	}
+
+	return matrix
+}
+
+func selectInstant(selector *selectorExpr, series []Series, t time.Time) []vectorSample {
+	var vector []vectorSample
+	end := t.Add(-selector.offset)
+	start := end.Add(-lookbackDelta)
+
+	for _, s := range selectedSeries(selector, series) {
+		var latest *point
+		for i, p := range s.points {
+			if p.t.After(start) && !p.t.After(end) {
+				latest = &s.points[i]
+			}
+		}
+		if latest != nil {
+			vector = append(vector, vectorSample{labels: s.Labels, point: point{t: t, v: latest.v}})
+		}
+	}
+
+	return vector
+}
+
+// applyRangeFunction computes the counter increase over every series, taking counter resets into account
+func applyRangeFunction(fn string, rng time.Duration, matrix []matrixSeries, t time.Time) []vectorSample {
+	var vector []vectorSample
+
+	for _, s := range matrix {
+		if len(s.points) < 2 {
+			continue
+		}
+
+		var increase float64
+		for i := 1; i < len(s.points); i++ {
+			delta := s.points[i].v - s.points[i-1].v
+			if delta < 0 {
+				delta = s.points[i].v
+			}
+			increase += delta
+		}
+		if fn == "rate" {
+			increase /= rng.Seconds()
+		}
+
+		vector = append(vector, vectorSample{labels: withoutName(s.labels), point: point{t: t, v: increase}})
+	}
+
+	return vector
+}
+
+func aggregate(fn string, vector []vectorSample, t time.Time) []vectorSample {
+	if len(vector) == 0 {
+		return nil
+	}
+
+	result := vector[0].point.v
+	if fn == "count" {
+		result = 1
+	}
+	for _, sample := range vector[1:] {
+		switch fn {
+		case "sum", "avg":
+			result += sample.point.v
+		case "count":
+			result++
+		case "min":
+			if sample.point.v < result {
+				result = sample.point.v
+			}
+		case "max":
+			if sample.point.v > result {
+				result = sample.point.v
+			}
+		}
+	}
+	if fn == "avg" {
+		result /= float64(len(vector))
+	}
+
+	return []vectorSample{{labels: map[string]string{}, point: point{t: t, v: result}}}
+}
+
+func withoutName(labels map[string]string) map[string]string {
+	stripped := map[string]string{}
+	for name, value := range labels {
+		if name != model.MetricNameLabel {
+			stripped[name] = value
+		}
+	}
+
+	return stripped
+}
+
+// labelsKey returns a string identifying a label set, used to merge the steps of a range query
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name + "=" + strconv.Quote(labels[name]) + ",")
+	}
+
+	return key.String()
+}
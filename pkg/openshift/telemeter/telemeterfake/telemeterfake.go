@@ -0,0 +1,460 @@
+// Package telemeterfake serves the Prometheus query API of an Observatorium tenant and an OIDC token endpoint
+// over an in-memory series store. It is meant for tests and local development, it understands just enough
+// PromQL for the queries of the acceptance test.
+package telemeterfake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	tokenPath     = "/oidc/token"
+	metricsPrefix = "/api/metrics/v1/"
+)
+
+// Options configures the fake Observatorium API
+type Options struct {
+	// Fixtures are JSON files holding the series to serve, see Fixture
+	Fixtures []string
+	// Now is the time the fixture samples are relative to and the default evaluation time, time.Now() when zero
+	Now time.Time
+	// Tenant is the Observatorium tenant served under /api/metrics/v1/, telemeter by default
+	Tenant string
+	// ClientID and ClientSecret are the credentials the token endpoint accepts, any credentials are accepted when empty
+	ClientID     string
+	ClientSecret string
+	// Latency is added to every response
+	Latency time.Duration
+}
+
+// Fixture is the content of a fixture file
+type Fixture struct {
+	Series []Series `json:"series"`
+}
+
+// Series is a time series of the store, its samples are given relative to the store time
+type Series struct {
+	Labels  map[string]string `json:"labels"`
+	Samples []Sample          `json:"samples"`
+
+	points []point
+}
+
+// Sample is a value of a series some time before the store time
+type Sample struct {
+	Ago   model.Duration `json:"ago"`
+	Value float64        `json:"value"`
+}
+
+// injectedError is a status returned instead of the query result for the requests matching a path prefix
+type injectedError struct {
+	pathPrefix string
+	status     int
+	remaining  int
+}
+
+// Handler is the http.Handler of the fake Observatorium API
+type Handler struct {
+	now          time.Time
+	tenant       string
+	clientID     string
+	clientSecret string
+
+	mu      sync.Mutex
+	latency time.Duration
+	series  []Series
+	tokens  map[string]bool
+	errors  []*injectedError
+	queries []string
+}
+
+// Server is a fake Observatorium API listening on a local address, like httptest.Server
+type Server struct {
+	*httptest.Server
+	*Handler
+}
+
+// NewHandler returns the handler of a fake Observatorium API serving the series of the fixtures of opts
+func NewHandler(opts Options) (*Handler, error) {
+	h := &Handler{
+		now:          opts.Now,
+		tenant:       opts.Tenant,
+		clientID:     opts.ClientID,
+		clientSecret: opts.ClientSecret,
+		latency:      opts.Latency,
+		tokens:       map[string]bool{},
+	}
+
+	if h.now.IsZero() {
+		h.now = time.Now()
+	}
+	if h.tenant == "" {
+		h.tenant = "telemeter"
+	}
+
+	for _, path := range opts.Fixtures {
+		var fixture Fixture
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+		err = json.Unmarshal(data, &fixture)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+		}
+
+		h.AddSeries(fixture.Series...)
+	}
+
+	return h, nil
+}
+
+// NewServer starts a fake Observatorium API on a local port, it must be closed by the caller
+func NewServer(opts Options) (*Server, error) {
+	h, err := NewHandler(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{Server: httptest.NewServer(h), Handler: h}, nil
+}
+
+// Now returns the time the samples are relative to
+func (h *Handler) Now() time.Time {
+	return h.now
+}
+
+// AddSeries adds series to the store
+func (h *Handler) AddSeries(series ...Series) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range series {
+		s.points = make([]point, 0, len(s.Samples))
+		for _, sample := range s.Samples {
+			s.points = append(s.points, point{t: h.now.Add(-time.Duration(sample.Ago)), v: sample.Value})
+		}
+		sort.Slice(s.points, func(i, j int) bool { return s.points[i].t.Before(s.points[j].t) })
+
+		h.series = append(h.series, s)
+	}
+}
+
+// InjectError makes the next times requests whose path starts with pathPrefix fail with status.
+// A negative times makes them fail until the error is cleared with ClearErrors.
+func (h *Handler) InjectError(pathPrefix string, status, times int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = append(h.errors, &injectedError{pathPrefix: pathPrefix, status: status, remaining: times})
+}
+
+// ClearErrors removes the errors injected with InjectError
+func (h *Handler) ClearErrors() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = nil
+}
+
+// SetLatency changes the delay added to every response
+func (h *Handler) SetLatency(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latency = latency
+}
+
+// Queries returns the queries received so far, in order
+func (h *Handler) Queries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.queries...)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	latency, injected := h.record(r)
+	logger := slog.With("component", "telemeterfake", "method", r.Method, "path", r.URL.Path)
+	logger.Debug("request received")
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if injected != 0 {
+		logger.Debug("returning injected error", "status", injected)
+		writeError(w, injected, "server_error", "injected error")
+		return
+	}
+
+	switch {
+	case r.URL.Path == discoveryPath:
+		h.serveDiscovery(w, r)
+	case r.URL.Path == tokenPath:
+		h.serveToken(w, r)
+	case strings.HasPrefix(r.URL.Path, metricsPrefix):
+		tenant, api, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, metricsPrefix), "/")
+		if tenant != h.tenant {
+			writeError(w, http.StatusNotFound, "not_found", "unknown tenant "+tenant)
+			return
+		}
+		if !h.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid access token")
+			return
+		}
+		h.serveAPI(w, r, "/"+api)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "path "+r.URL.Path+" is not served by the fake Observatorium API")
+	}
+}
+
+// record keeps the query and returns the latency to apply and the injected status, zero if there is none
+func (h *Handler) record(r *http.Request) (time.Duration, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if query := r.URL.Query().Get("query"); query != "" {
+		h.queries = append(h.queries, query)
+	}
+
+	for _, injected := range h.errors {
+		if !strings.HasPrefix(r.URL.Path, injected.pathPrefix) || injected.remaining == 0 {
+			continue
+		}
+		if injected.remaining > 0 {
+			injected.remaining--
+		}
+		return h.latency, injected.status
+	}
+
+	return h.latency, 0
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && h.tokens[token]
+}
+
+// serveDiscovery returns the OIDC provider configuration, the server is its own issuer
+func (h *Handler) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := "http://" + r.Host
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                issuer,
+		"token_endpoint":        issuer + tokenPath,
+		"grant_types_supported": []string{"client_credentials"},
+	})
+}
+
+// serveToken issues an opaque access token for the client credentials grant
+func (h *Handler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.FormValue("grant_type") != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if h.clientID != "" && (clientID != h.clientID || clientSecret != h.clientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	random := make([]byte, 16)
+	rand.Read(random)
+	token := hex.EncodeToString(random)
+
+	h.mu.Lock()
+	h.tokens[token] = true
+	h.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// serveAPI serves the query and query_range endpoints of the Prometheus HTTP API
+func (h *Handler) serveAPI(w http.ResponseWriter, r *http.Request, api string) {
+	if api != "/api/v1/query" && api != "/api/v1/query_range" {
+		writeError(w, http.StatusNotFound, "not_found", "endpoint "+api+" is not served by the fake Observatorium API")
+		return
+	}
+
+	query, err := parseQuery(r.FormValue("query"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid parameter \"query\": "+err.Error())
+		return
+	}
+
+	h.mu.Lock()
+	series := h.series
+	h.mu.Unlock()
+
+	if api == "/api/v1/query" {
+		t, err := parseTime(r.FormValue("time"), h.now)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_data", "invalid parameter \"time\": "+err.Error())
+			return
+		}
+
+		value, err := evaluate(query, series, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+			return
+		}
+
+		writeResult(w, value)
+		return
+	}
+
+	start, err := parseTime(r.FormValue("start"), time.Time{})
+	if err != nil || start.IsZero() {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid parameter \"start\"")
+		return
+	}
+	end, err := parseTime(r.FormValue("end"), time.Time{})
+	if err != nil || end.IsZero() || end.Before(start) {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid parameter \"end\"")
+		return
+	}
+	step, err := parseStep(r.FormValue("step"))
+	if err != nil || step <= 0 {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid parameter \"step\"")
+		return
+	}
+
+	var matrix []matrixSeries
+	index := map[string]int{}
+	for t := start; !t.After(end); t = t.Add(step) {
+		value, err := evaluate(query, series, t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+			return
+		}
+		vector, ok := value.([]vectorSample)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "bad_data", "invalid expression type \"range vector\" for range query, must be Scalar or instant Vector")
+			return
+		}
+
+		for _, sample := range vector {
+			key := labelsKey(sample.labels)
+			i, ok := index[key]
+			if !ok {
+				i = len(matrix)
+				index[key] = i
+				matrix = append(matrix, matrixSeries{labels: sample.labels})
+			}
+			matrix[i].points = append(matrix[i].points, sample.point)
+		}
+	}
+
+	writeResult(w, matrix)
+}
+
+// writeResult writes a vector or a matrix in the format of the Prometheus HTTP API
+func writeResult(w http.ResponseWriter, value interface{}) {
+	result := []map[string]interface{}{}
+	resultType := "vector"
+
+	switch value := value.(type) {
+	case []vectorSample:
+		for _, sample := range value {
+			result = append(result, map[string]interface{}{"metric": sample.labels, "value": formatPoint(sample.point)})
+		}
+	case []matrixSeries:
+		resultType = "matrix"
+		for _, series := range value {
+			values := make([][]interface{}, 0, len(series.points))
+			for _, p := range series.points {
+				values = append(values, formatPoint(p))
+			}
+			result = append(result, map[string]interface{}{"metric": series.labels, "values": values})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"resultType": resultType,
+			"result":     result,
+		},
+	})
+}
+
+func formatPoint(p point) []interface{} {
+	return []interface{}{float64(p.t.UnixMilli()) / 1000, strconv.FormatFloat(p.v, 'f', -1, 64)}
+}
+
+// parseTime parses a Prometheus API timestamp, either unix seconds or RFC 3339
+func parseTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseStep parses a Prometheus API step, either seconds or a duration
+func parseStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	step, err := model.ParseDuration(value)
+
+	return time.Duration(step), err
+}
+
+// writeError writes an error in the format of the Prometheus HTTP API
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	writeJSON(w, status, map[string]string{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,93 @@
+package telemeterfake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	h, err := NewHandler(Options{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AddSeries(
+		Series{
+			Labels: map[string]string{"__name__": "restarts_total", "_id": "a", "namespace": "example-operator"},
+			Samples: []Sample{
+				{Ago: model.Duration(25 * time.Hour), Value: 1},
+				{Ago: model.Duration(24*time.Hour + time.Minute), Value: 2},
+				{Ago: model.Duration(9 * time.Minute), Value: 5},
+				{Ago: model.Duration(5 * time.Minute), Value: 1},
+				{Ago: model.Duration(time.Minute), Value: 4},
+			},
+		},
+		Series{
+			Labels:  map[string]string{"__name__": "restarts_total", "_id": "b", "namespace": "other"},
+			Samples: []Sample{{Ago: model.Duration(time.Minute), Value: 7}},
+		},
+	)
+
+	tests := []struct {
+		query  string
+		series int
+		value  float64
+	}{
+		{query: `restarts_total`, series: 2, value: 4},
+		{query: `restarts_total{_id="a"}[10m]`, series: 1, value: 4},
+		{query: `restarts_total{namespace=~".*operator.*"}[2m]`, series: 1, value: 4},
+		{query: `restarts_total{namespace!~".*operator.*"}`, series: 1, value: 7},
+		{query: `sum(increase(restarts_total{_id="a"}[10m]))`, series: 1, value: 4},
+		{query: `sum(increase(restarts_total{_id="a"}[2h] offset 24h))`, series: 1, value: 1},
+		{query: `count(restarts_total)`, series: 1, value: 2},
+		{query: `max(restarts_total)`, series: 1, value: 7},
+		{query: `restarts_total{_id="missing"}[10m]`, series: 0},
+		{query: `sum(restarts_total{_id="missing"})`, series: 0},
+	}
+
+	for _, tt := range tests {
+		e, err := parseQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseQuery(%s) error = %v", tt.query, err)
+			continue
+		}
+		value, err := evaluate(e, h.series, now)
+		if err != nil {
+			t.Errorf("evaluate(%s) error = %v", tt.query, err)
+			continue
+		}
+
+		var series int
+		var latest float64
+		switch value := value.(type) {
+		case []vectorSample:
+			series = len(value)
+			if series > 0 {
+				latest = value[0].point.v
+			}
+		case []matrixSeries:
+			series = len(value)
+			if series > 0 {
+				latest = value[0].points[len(value[0].points)-1].v
+			}
+		}
+		if series != tt.series || series > 0 && latest != tt.value {
+			t.Errorf("%s = %d series with latest value %v, want %d series with %v", tt.query, series, latest, tt.series, tt.value)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`sum by (_id) (up)`,
+		`increase(up)`,
+		`up{_id="a"`,
+		`histogram_quantile(0.9, up)`,
+	} {
+		if _, err := parseQuery(query); err == nil {
+			t.Errorf("parseQuery(%s) succeeded, want an error", query)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package replay records the responses of OCM and Telemeter during a run and serves them back,
+// so that a run can be reproduced offline.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+)
+
+// RunFile is the file of a recording holding the configuration of the recorded run
+const RunFile = "run.json"
+
+// ErrNotRecorded is returned when replaying a request which is not part of the recording
+var ErrNotRecorded = errors.New("request was not recorded")
+
+// secretFields matches the JSON fields whose values are scrubbed from the recorded responses
+var secretFields = regexp.MustCompile(`(?i)(secret|token|password|credential|access_key|key_id|private_key|pull_secret|kubeconfig)`)
+
+type recorderKey struct{}
+type playerKey struct{}
+
+// Interaction is a request sent to a service along with its response or error
+type Interaction struct {
+	Service  string          `json:"service"`
+	Request  string          `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	// Text is set when the response is not JSON, Response then holds it as a JSON string
+	Text    bool   `json:"text,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Timeout bool   `json:"timeout,omitempty"`
+}
+
+// Recorder writes every interaction of a run to a directory, one file per interaction
+type Recorder struct {
+	dir      string
+	scrubber helpers.Scrubber
+
+	mu    sync.Mutex
+	count int
+}
+
+// Player serves the interactions of a recording back, in the order they were recorded
+type Player struct {
+	dir string
+
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+// NewRecorder returns a recorder writing to dir, which is created if needed.
+// The secrets are replaced with REDACTED wherever they appear in the recorded requests, responses and errors,
+// the fields of JSON responses which look like they hold secrets are scrubbed too.
+func NewRecorder(dir string, secrets ...string) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the recording directory: %w", err)
+	}
+
+	recorder := &Recorder{dir: dir, scrubber: helpers.NewScrubber(secrets...)}
+
+	return recorder, nil
+}
+
+// NewPlayer loads the interactions recorded in dir
+func NewPlayer(dir string) (*Player, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "[0-9]*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no interactions recorded in %s", dir)
+	}
+	sort.Strings(paths)
+
+	player := &Player{dir: dir, interactions: map[string][]Interaction{}}
+	for _, path := range paths {
+		var interaction Interaction
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recorded interaction: %w", err)
+		}
+		err = json.Unmarshal(data, &interaction)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse recorded interaction %s: %v", path, err)
+		}
+
+		key := interactionKey(interaction.Service, interaction.Request)
+		player.interactions[key] = append(player.interactions[key], interaction)
+	}
+
+	return player, nil
+}
+
+// WithRecorder returns a copy of ctx where every interaction is recorded in recorder
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, recorder)
+}
+
+// WithPlayer returns a copy of ctx where every interaction is served by player instead of the service
+func WithPlayer(ctx context.Context, player *Player) context.Context {
+	return context.WithValue(ctx, playerKey{}, player)
+}
+
+// Replaying reports whether the interactions are served from a recording
+func Replaying(ctx context.Context) bool {
+	player, _ := ctx.Value(playerKey{}).(*Player)
+	return player != nil
+}
+
+// Do sends request to service with fn, recording the interaction or serving it from the recording
+// depending on ctx. Without a recorder or a player it just calls fn.
+func Do(ctx context.Context, service, request string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	if player, _ := ctx.Value(playerKey{}).(*Player); player != nil {
+		return player.play(service, request)
+	}
+
+	response, err := fn(ctx)
+
+	if recorder, _ := ctx.Value(recorderKey{}).(*Recorder); recorder != nil {
+		recordErr := recorder.record(service, request, response, err)
+		if recordErr != nil {
+			return response, errors.Join(err, recordErr)
+		}
+	}
+
+	return response, err
+}
+
+// WriteRun writes the configuration of the run next to the interactions, with the secrets scrubbed
+func (r *Recorder) WriteRun(run map[string]interface{}) error {
+	data, err := marshal(run)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(r.dir, RunFile), []byte(r.scrubber.Scrub(string(data))), 0o644)
+}
+
+func (r *Recorder) record(service, request string, response []byte, err error) error {
+	interaction := Interaction{Service: service, Request: r.scrubber.Scrub(request)}
+
+	if err != nil {
+		interaction.Error = r.scrubber.Scrub(err.Error())
+		interaction.Timeout = errors.Is(err, context.DeadlineExceeded)
+	} else if json.Valid(response) {
+		interaction.Response = scrubJSON(json.RawMessage(r.scrubber.Scrub(string(response))))
+	} else {
+		interaction.Text = true
+		interaction.Response, _ = json.Marshal(r.scrubber.Scrub(string(response)))
+	}
+
+	data, marshalErr := marshal(interaction)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count++
+	path := filepath.Join(r.dir, fmt.Sprintf("%04d-%s.json", r.count, service))
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// play returns the next recorded response to request. The last one is served again once the others were played,
+// so a replay asking for something more often than the recorded run still gets an answer.
+func (p *Player) play(service, request string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := interactionKey(service, request)
+	queue := p.interactions[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("%w: %s %s in %s", ErrNotRecorded, service, request, p.dir)
+	}
+
+	interaction := queue[0]
+	if len(queue) > 1 {
+		p.interactions[key] = queue[1:]
+	}
+
+	if interaction.Timeout {
+		return nil, fmt.Errorf("%s: %w", interaction.Error, context.DeadlineExceeded)
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	if interaction.Text {
+		var text string
+		err := json.Unmarshal(interaction.Response, &text)
+		return []byte(text), err
+	}
+
+	return interaction.Response, nil
+}
+
+// marshal indents value without escaping the HTML characters, which are common in the recorded URLs and queries
+func marshal(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+
+	return buffer.Bytes(), err
+}
+
+func interactionKey(service, request string) string {
+	return service + " " + request
+}
+
+// scrubJSON replaces the values of the fields which look like they hold secrets
+func scrubJSON(data json.RawMessage) json.RawMessage {
+	var value interface{}
+
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return data
+	}
+
+	scrubbed, err := json.Marshal(scrubValue(value))
+	if err != nil {
+		return data
+	}
+
+	return scrubbed
+}
+
+func scrubValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range value {
+			if _, isString := fieldValue.(string); isString && secretFields.MatchString(field) {
+				value[field] = helpers.Redacted
+				continue
+			}
+			value[field] = scrubValue(fieldValue)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = scrubValue(value[i])
+		}
+	}
+
+	return value
+}
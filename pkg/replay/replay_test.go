@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndPlay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, "s3cr3t-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRecorder(context.Background(), recorder)
+
+	responses := []struct {
+		body []byte
+		err  error
+	}{
+		{err: errors.New("status is 503: token s3cr3t-token rejected")},
+		{body: []byte(`{"id":"a","aws":{"access_key_id":"AKIA","secret_access_key":"xyz"},"name":"s3cr3t-token-cluster"}`)},
+	}
+	for _, response := range responses {
+		Do(ctx, "ocm", "GET /clusters/a", func(ctx context.Context) ([]byte, error) {
+			return response.body, response.err
+		})
+	}
+	Do(ctx, "telemeter", "up", func(ctx context.Context) ([]byte, error) {
+		return []byte("not json"), nil
+	})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		for _, secret := range []string{"s3cr3t-token", "AKIA", "xyz"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains the secret %q: %s", file, secret, data)
+			}
+		}
+	}
+
+	player, err := NewPlayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = WithPlayer(context.Background(), player)
+	fail := func(ctx context.Context) ([]byte, error) {
+		t.Fatal("the service was called while replaying")
+		return nil, nil
+	}
+
+	_, err = Do(ctx, "ocm", "GET /clusters/a", fail)
+	if err == nil || !strings.Contains(err.Error(), "status is 503") {
+		t.Errorf("first replayed response error = %v, want the recorded 503", err)
+	}
+	for i := 0; i < 2; i++ {
+		body, err := Do(ctx, "ocm", "GET /clusters/a", fail)
+		if err != nil || !strings.Contains(string(body), `"REDACTED-cluster"`) {
+			t.Errorf("replayed response = %s, %v, want the recorded cluster", body, err)
+		}
+	}
+	body, err := Do(ctx, "telemeter", "up", fail)
+	if err != nil || string(body) != "not json" {
+		t.Errorf("replayed text response = %q, %v", body, err)
+	}
+	_, err = Do(ctx, "telemeter", "down", fail)
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded request error = %v, want ErrNotRecorded", err)
+	}
+}
@@ -0,0 +1,149 @@
+package report
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sparklineWidth   = 240
+	sparklineHeight  = 40
+	sparklinePadding = 3
+)
+
+// sparklineColors are the strokes of the series of a sparkline, in order
+var sparklineColors = []string{"#0066cc", "#c9190b", "#3e8635", "#f0ab00", "#6753ac", "#009596"}
+
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// WriteHTML writes the report as a single HTML file, with the samples of every query plotted as inline SVG sparklines
+func (r *Report) WriteHTML(path string, links *Links) error {
+	data, err := r.HTML(links)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+
+	return nil
+}
+
+// HTML renders the report as a self-contained HTML page, links may be nil
+func (r *Report) HTML(links *Links) ([]byte, error) {
+	var page bytes.Buffer
+
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"sparkline": sparkline,
+		"lower":     strings.ToLower,
+		"queryURL": func(cluster ClusterResult, query string) string {
+			return links.QueryURL(r.linkData(cluster, query))
+		},
+		"dashboardURL": func(cluster ClusterResult) string {
+			return links.DashboardURL(r.linkData(cluster, ""))
+		},
+		"time": func(t time.Time) string {
+			return t.UTC().Format(time.RFC3339)
+		},
+		"duration": func(start, end time.Time) string {
+			return end.Sub(start).Round(time.Second).String()
+		},
+	}).Parse(htmlTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the HTML report template: %w", err)
+	}
+
+	err = tmpl.Execute(&page, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the HTML report: %w", err)
+	}
+
+	return page.Bytes(), nil
+}
+
+// sparkline plots the series as an inline SVG, every series scaled to the same time and value ranges
+func sparkline(series []Series) template.HTML {
+	var b strings.Builder
+	var start, end time.Time
+	low, high := math.Inf(1), math.Inf(-1)
+
+	for _, s := range series {
+		for _, sample := range s.Samples {
+			if !plottable(sample) {
+				continue
+			}
+			if start.IsZero() || sample.Time.Before(start) {
+				start = sample.Time
+			}
+			if sample.Time.After(end) {
+				end = sample.Time
+			}
+			low, high = math.Min(low, sample.Value), math.Max(high, sample.Value)
+		}
+	}
+	if start.IsZero() {
+		return template.HTML(`<span class="nodata">no samples</span>`)
+	}
+
+	x := func(t time.Time) float64 {
+		if !end.After(start) {
+			return sparklineWidth / 2
+		}
+		return sparklinePadding + float64(t.Sub(start))/float64(end.Sub(start))*(sparklineWidth-2*sparklinePadding)
+	}
+	y := func(value float64) float64 {
+		if high == low {
+			return sparklineHeight / 2
+		}
+		return sparklineHeight - sparklinePadding - (value-low)/(high-low)*(sparklineHeight-2*sparklinePadding)
+	}
+
+	fmt.Fprintf(&b, `<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" role="img">`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight)
+	fmt.Fprintf(&b, `<title>%s to %s, min %g, max %g</title>`, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), low, high)
+	for i, s := range series {
+		color := sparklineColors[i%len(sparklineColors)]
+		name := template.HTMLEscapeString(s.Name)
+
+		var samples []Sample
+		for _, sample := range s.Samples {
+			if plottable(sample) {
+				samples = append(samples, sample)
+			}
+		}
+
+		switch len(samples) {
+		case 0:
+			continue
+		case 1:
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2" fill="%s"><title>%s: %g</title></circle>`,
+				x(samples[0].Time), y(samples[0].Value), color, name, samples[0].Value)
+			continue
+		}
+
+		points := make([]string, 0, len(samples))
+		for _, sample := range samples {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(sample.Time), y(sample.Value)))
+		}
+		last := samples[len(samples)-1].Value
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"><title>%s: last %g</title></polyline>`,
+			strings.Join(points, " "), color, name, last)
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
+
+// plottable reports whether the sample has a value which can be placed on a sparkline, NaN and infinite values cannot
+func plottable(sample Sample) bool {
+	return !math.IsNaN(sample.Value) && !math.IsInf(sample.Value, 0)
+}
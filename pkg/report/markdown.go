@@ -0,0 +1,224 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Links renders the links to Observatorium, Grafana or any other tool from URL templates.
+// The templates are text/template rendered with a LinkData, e.g. https://grafana.example.com/explore?expr={{urlquery .Query}}
+type Links struct {
+	query     *template.Template
+	dashboard *template.Template
+}
+
+// LinkData is the data the link templates are rendered with, Query is empty for the dashboard links of a cluster
+type LinkData struct {
+	Environment string
+	Operator    string
+	ImageTag    string
+	ClusterID   string
+	ExternalID  string
+	Query       string
+	// Start and End bound the run, for links to a time range
+	Start time.Time
+	End   time.Time
+}
+
+// NewLinks parses the URL templates of the links to a query and to the dashboard of a cluster, empty templates render no link
+func NewLinks(queryTemplate, dashboardTemplate string) (*Links, error) {
+	var err error
+	links := &Links{}
+
+	if queryTemplate != "" {
+		links.query, err = template.New("query").Option("missingkey=error").Parse(queryTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query URL template: %v", err)
+		}
+	}
+	if dashboardTemplate != "" {
+		links.dashboard, err = template.New("dashboard").Option("missingkey=error").Parse(dashboardTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dashboard URL template: %v", err)
+		}
+	}
+
+	// Unknown fields only show up once the templates are executed
+	for _, tmpl := range []*template.Template{links.query, links.dashboard} {
+		if tmpl == nil {
+			continue
+		}
+		err = tmpl.Execute(io.Discard, LinkData{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s URL template: %v", tmpl.Name(), err)
+		}
+	}
+
+	return links, nil
+}
+
+// WriteMarkdown writes the report as a Markdown summary to path, e.g. the file of $GITHUB_STEP_SUMMARY
+func (r *Report) WriteMarkdown(path string, links *Links) error {
+	err := os.WriteFile(path, []byte(r.Markdown(links)), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write markdown report: %w", err)
+	}
+
+	return nil
+}
+
+// Markdown renders the report as a Markdown summary: the run header, a table of the clusters,
+// the failing queries and the errors. links may be nil.
+func (r *Report) Markdown(links *Links) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## Acceptance test %s\n\n", r.Verdict)
+	fmt.Fprintf(&b, "| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Operator | `%s` |\n", r.Operator)
+	fmt.Fprintf(&b, "| Imagetag | `%s` |\n", r.ImageTag)
+	fmt.Fprintf(&b, "| Environment | %s |\n", r.Environment)
+	fmt.Fprintf(&b, "| Selectors | %s |\n", markdownCell(strings.Join(r.Selectors, ", ")))
+	fmt.Fprintf(&b, "| Mode | %s |\n", r.Mode)
+	fmt.Fprintf(&b, "| Started | %s |\n", r.StartTime.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "| Duration | %s |\n", r.EndTime.Sub(r.StartTime).Round(time.Second))
+	if r.Coverage != nil {
+		fmt.Fprintf(&b, "| Coverage | %d of %d clusters verified (%.1f%%) |\n", r.Coverage.Verified, r.Coverage.Selected, r.Coverage.Percent)
+	}
+	if len(r.Retries) > 0 {
+		fmt.Fprintf(&b, "| Retried calls | %d |\n", len(r.Retries))
+	}
+
+	var clusters []ClusterResult
+	for _, kind := range r.Kinds {
+		clusters = append(clusters, kind.Clusters...)
+	}
+
+	if len(clusters) > 0 {
+		fmt.Fprintf(&b, "\n### Clusters\n\n")
+		fmt.Fprintf(&b, "| Kind | Cluster | External ID | Status | Checks passed | Links |\n|---|---|---|---|---|---|\n")
+		for _, cluster := range clusters {
+			passed := 0
+			for _, check := range cluster.Checks {
+				if check.Passed {
+					passed++
+				}
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | `%s` | %s | %d of %d | %s |\n", cluster.Kind, cluster.ClusterID, cluster.ExternalID,
+				cluster.Verdict, passed, len(cluster.Checks), markdownLink("dashboard", links.DashboardURL(r.linkData(cluster, ""))))
+		}
+	}
+
+	var failing strings.Builder
+	for _, cluster := range clusters {
+		for _, check := range cluster.Checks {
+			if !check.Passed {
+				r.writeFailingQuery(&failing, links, cluster, check.Name, check.Query, fmt.Sprintf("%d series", check.Series))
+			}
+		}
+		for _, comparison := range cluster.Comparisons {
+			if comparison.Regressed {
+				r.writeFailingQuery(&failing, links, cluster, comparison.Signal, comparison.CurrentQuery,
+					fmt.Sprintf("%v, baseline %v", comparison.Current, comparison.Baseline))
+				r.writeFailingQuery(&failing, links, cluster, comparison.Signal+" baseline", comparison.BaselineQuery,
+					fmt.Sprintf("%v", comparison.Baseline))
+			}
+		}
+	}
+	if failing.Len() > 0 {
+		fmt.Fprintf(&b, "\n### Failing queries\n\n%s", failing.String())
+	}
+
+	if r.Canary != nil {
+		fmt.Fprintf(&b, "\n### Canary analysis\n\n")
+		fmt.Fprintf(&b, "Score %.1f, %s, %d clusters on the new version and %d on the old one.\n\n",
+			r.Canary.Score, r.Canary.Classification, len(r.Canary.NewClusters), len(r.Canary.OldClusters))
+		if len(r.Canary.Metrics) > 0 {
+			fmt.Fprintf(&b, "| Metric | Status | p-value | Effect |\n|---|---|---|---|\n")
+			for _, metric := range r.Canary.Metrics {
+				fmt.Fprintf(&b, "| %s | %s | %.4f | %.2f |\n", markdownCell(metric.Metric), metric.Classification, metric.PValue, metric.Effect)
+			}
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(&b, "\n### Errors\n\n")
+		for _, err := range r.Errors {
+			fmt.Fprintf(&b, "```\n%s\n```\n", err)
+		}
+	}
+
+	return b.String()
+}
+
+func (r *Report) writeFailingQuery(b *strings.Builder, links *Links, cluster ClusterResult, name, query, result string) {
+	fmt.Fprintf(b, "- %s on `%s` returned %s", markdownCell(name), cluster.ExternalID, result)
+	if link := links.QueryURL(r.linkData(cluster, query)); link != "" {
+		fmt.Fprintf(b, " (%s)", markdownLink("query", link))
+	}
+	fmt.Fprintf(b, "\n  ```promql\n  %s\n  ```\n", query)
+}
+
+func (r *Report) linkData(cluster ClusterResult, query string) LinkData {
+	return LinkData{
+		Environment: r.Environment,
+		Operator:    r.Operator,
+		ImageTag:    r.ImageTag,
+		ClusterID:   cluster.ClusterID,
+		ExternalID:  cluster.ExternalID,
+		Query:       query,
+		Start:       r.StartTime,
+		End:         r.EndTime,
+	}
+}
+
+func markdownLink(text, url string) string {
+	if url == "" {
+		return ""
+	}
+
+	return "[" + text + "](" + url + ")"
+}
+
+// QueryURL returns the link to the query of the data, empty without a query URL template
+func (l *Links) QueryURL(data LinkData) string {
+	if l == nil {
+		return ""
+	}
+
+	return renderLink(l.query, data)
+}
+
+// DashboardURL returns the link to the dashboard of the cluster of the data, empty without a dashboard URL template
+func (l *Links) DashboardURL(data LinkData) string {
+	if l == nil {
+		return ""
+	}
+
+	return renderLink(l.dashboard, data)
+}
+
+// renderLink renders a link template, a missing or failing template renders no link
+func renderLink(tmpl *template.Template, data LinkData) string {
+	var url bytes.Buffer
+
+	if tmpl == nil {
+		return ""
+	}
+
+	err := tmpl.Execute(&url, data)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(url.String())
+}
+
+// markdownCell escapes the text of a table cell
+func markdownCell(text string) string {
+	return strings.ReplaceAll(text, "|", "\\|")
+}
@@ -0,0 +1,134 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+)
+
+// Report is the result document of an acceptance test run
+type Report struct {
+	Operator    string          `json:"operator"`
+	ImageTag    string          `json:"imagetag"`
+	Environment string          `json:"environment"`
+	Selectors   []string        `json:"selectors"`
+	Mode        string          `json:"mode"`
+	Verdict     string          `json:"verdict"`
+	StartTime   time.Time       `json:"start_time"`
+	EndTime     time.Time       `json:"end_time"`
+	Kinds       []KindResult    `json:"kinds,omitempty"`
+	Coverage    *Coverage       `json:"coverage,omitempty"`
+	Canary      *CanaryAnalysis `json:"canary,omitempty"`
+	Errors      []string        `json:"errors,omitempty"`
+	Retries     []retry.Attempt `json:"retries,omitempty"`
+}
+
+// KindResult groups the results of the clusters of one kind, management or service, and the checks run on them
+type KindResult struct {
+	Kind     string          `json:"kind"`
+	Checks   []string        `json:"checks"`
+	Verdict  string          `json:"verdict"`
+	Clusters []ClusterResult `json:"clusters"`
+}
+
+// ClusterResult holds the verdict of a single cluster and the checks run against it
+type ClusterResult struct {
+	ClusterID   string        `json:"cluster_id"`
+	Kind        string        `json:"kind"`
+	ExternalID  string        `json:"external_id"`
+	Verdict     string        `json:"verdict"`
+	Checks      []CheckResult `json:"checks,omitempty"`
+	Comparisons []Comparison  `json:"comparisons,omitempty"`
+}
+
+// Coverage tells how many of the clusters matching the selectors reached a PASSED or FAILED verdict
+type Coverage struct {
+	Selected int                     `json:"selected"`
+	Verified int                     `json:"verified"`
+	Percent  float64                 `json:"percent"`
+	ByKind   map[string]KindCoverage `json:"by_kind"`
+}
+
+// KindCoverage is the coverage of a single kind of cluster
+type KindCoverage struct {
+	Selected int `json:"selected"`
+	Verified int `json:"verified"`
+}
+
+// CheckResult is the outcome of a single telemeter query
+type CheckResult struct {
+	Name    string   `json:"name"`
+	Query   string   `json:"query"`
+	Series  int      `json:"series"`
+	Passed  bool     `json:"passed"`
+	Samples []Series `json:"samples,omitempty"`
+}
+
+// Comparison is a health signal evaluated for the baseline and the new operator version
+type Comparison struct {
+	Signal          string   `json:"signal"`
+	BaselineQuery   string   `json:"baseline_query"`
+	CurrentQuery    string   `json:"current_query"`
+	Baseline        float64  `json:"baseline"`
+	Current         float64  `json:"current"`
+	Regressed       bool     `json:"regressed"`
+	BaselineSamples []Series `json:"baseline_samples,omitempty"`
+	CurrentSamples  []Series `json:"current_samples,omitempty"`
+}
+
+// Series holds the raw samples of a series returned by a telemeter query
+type Series struct {
+	Name    string   `json:"name"`
+	Samples []Sample `json:"samples"`
+}
+
+// Sample is the value of a series at a point in time
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// AddErrors records the error messages of errs in the report
+func (r *Report) AddErrors(errs ...error) {
+	for _, err := range errs {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// WriteJSON writes the report as indented JSON to path
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
+
+// CanaryAnalysis compares the clusters already running the new imagetag against the ones still on the old version
+type CanaryAnalysis struct {
+	NewClusters    []string         `json:"new_clusters"`
+	OldClusters    []string         `json:"old_clusters"`
+	Score          float64          `json:"score"`
+	Classification string           `json:"classification"`
+	Metrics        []MetricAnalysis `json:"metrics"`
+}
+
+// MetricAnalysis is the Mann-Whitney U test of one metric between the new and old populations
+type MetricAnalysis struct {
+	Metric         string    `json:"metric"`
+	NewValues      []float64 `json:"new_values"`
+	OldValues      []float64 `json:"old_values"`
+	U              float64   `json:"u"`
+	PValue         float64   `json:"p_value"`
+	Effect         float64   `json:"effect"`
+	Classification string    `json:"classification"`
+}
@@ -0,0 +1,136 @@
+package report
+
+import (
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the report tests")
+
+// testReport returns a failed run with a passed management cluster and a service cluster failing a check and a baseline signal
+func testReport() *Report {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return &Report{
+		Operator:    "example-operator",
+		ImageTag:    "abc123",
+		Environment: "stage",
+		Selectors:   []string{"us-east-1", "main"},
+		Mode:        "acceptance",
+		Verdict:     "FAILED",
+		StartTime:   start,
+		EndTime:     start.Add(95 * time.Second),
+		Kinds: []KindResult{
+			{
+				Kind:    "ManagementCluster",
+				Checks:  []string{"liveness", "csv_succeeded"},
+				Verdict: "PASSED",
+				Clusters: []ClusterResult{{
+					ClusterID: "mc-1", Kind: "ManagementCluster", ExternalID: "ext-mc-1", Verdict: "PASSED",
+					Checks: []CheckResult{
+						{Name: "liveness", Query: `up{_id="ext-mc-1"}[10m]`, Series: 1, Passed: true},
+						{Name: "csv_succeeded", Query: `csv_succeeded{_id="ext-mc-1", name=~"example-operator.*abc123"}[10m]`, Series: 1, Passed: true},
+					},
+				}},
+			},
+			{
+				Kind:    "ServiceCluster",
+				Checks:  []string{"liveness", "csv_abnormal", "baseline"},
+				Verdict: "FAILED",
+				Clusters: []ClusterResult{{
+					ClusterID: "sc-1", Kind: "ServiceCluster", ExternalID: "ext-sc-1", Verdict: "FAILED",
+					Checks: []CheckResult{
+						{Name: "liveness", Query: `up{_id="ext-sc-1"}[10m]`, Series: 1, Passed: true,
+							Samples: []Series{{Name: "up", Samples: samples(start, 1, 1, 1, 1)}}},
+						{Name: "csv_abnormal", Query: `csv_abnormal{_id="ext-sc-1", name=~"example-operator.*abc123|other"}[10m]`, Series: 2,
+							Samples: []Series{
+								{Name: `csv_abnormal{name="example-operator.abc123"}`, Samples: samples(start, 0, 1, 1)},
+								{Name: `csv_abnormal{name="<other>"}`, Samples: samples(start, 1)},
+							}},
+					},
+					Comparisons: []Comparison{{
+						Signal:          "restarts",
+						CurrentQuery:    `sum(increase(restarts{_id="ext-sc-1"}[10m]))`,
+						BaselineQuery:   `sum(increase(restarts{_id="ext-sc-1"}[10m] offset 24h))`,
+						Current:         12,
+						Baseline:        1,
+						Regressed:       true,
+						CurrentSamples:  []Series{{Name: "restarts", Samples: samples(start, 2, 5, 12)}},
+						BaselineSamples: []Series{{Name: "restarts", Samples: samples(start.Add(-24*time.Hour), 1, 1, math.NaN())}},
+					}},
+				}},
+			},
+		},
+		Coverage: &Coverage{Selected: 2, Verified: 2, Percent: 100},
+		Errors:   []string{"acceptance check failed: csv_abnormal count is greater than 0 for cluster ext-sc-1"},
+	}
+}
+
+// samples returns the values as samples a minute apart, ending at end
+func samples(end time.Time, values ...float64) []Sample {
+	var samples []Sample
+
+	for i, value := range values {
+		samples = append(samples, Sample{Time: end.Add(time.Duration(i-len(values)+1) * time.Minute), Value: value})
+	}
+
+	return samples
+}
+
+// golden compares got with the golden file testdata/name, run go test ./pkg/report -update to regenerate them
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+
+	if *update {
+		err := os.WriteFile(path, []byte(got), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	links, err := NewLinks("https://grafana.example.com/explore?env={{.Environment}}&expr={{urlquery .Query}}",
+		"https://grafana.example.com/d/operator?var-cluster={{.ExternalID}}&from={{.Start.Unix}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden(t, "report.md", testReport().Markdown(links))
+}
+
+func TestHTML(t *testing.T) {
+	links, err := NewLinks("https://grafana.example.com/explore?expr={{urlquery .Query}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := testReport().HTML(links)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden(t, "report.html", string(page))
+}
+
+func TestNewLinksInvalidTemplate(t *testing.T) {
+	for _, tmpl := range []string{"https://example.com/{{.Query", "https://example.com/{{.Unknown}}"} {
+		_, err := NewLinks(tmpl, "")
+		if err == nil {
+			t.Errorf("NewLinks(%q) succeeded, want an error", tmpl)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+)
+
+type policyKey struct{}
+type recorderKey struct{}
+
+// Policy describes how often and how fast a failing operation is retried
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff randomly added or removed, between 0 and 1
+	Jitter float64
+}
+
+// Attempt is a failed try of an operation
+type Attempt struct {
+	Operation string    `json:"operation"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+	Retryable bool      `json:"retryable"`
+	Time      time.Time `json:"time"`
+}
+
+// Recorder collects the failed attempts of every operation run with Do
+type Recorder struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// transientErrors matches the error output of ocm and obsctl for failures worth retrying:
+// throttling, gateway and server errors as well as network hiccups.
+var transientErrors = regexp.MustCompile(`(?i)(status( code)?( is|:)? ?(429|500|502|503|504)\b|\b(429|502|503|504) (too many requests|bad gateway|service unavailable|gateway timeout)|connection (refused|reset)|i/o timeout|TLS handshake timeout|unexpected EOF|no such host|temporary failure)`)
+
+// DefaultPolicy returns the policy used when none is stored in the context
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    3,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Validate checks that the jitter of the policy is a fraction, a larger one would turn the backoff negative
+func (p Policy) Validate() error {
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry-jitter must be between 0 and 1, got %v", p.Jitter)
+	}
+
+	return nil
+}
+
+// WithPolicy returns a copy of ctx carrying the retry policy used by Do
+func WithPolicy(ctx context.Context, policy Policy) context.Context {
+	return context.WithValue(ctx, policyKey{}, policy)
+}
+
+// PolicyFrom returns the retry policy stored in ctx or the default policy
+func PolicyFrom(ctx context.Context) Policy {
+	if policy, ok := ctx.Value(policyKey{}).(Policy); ok {
+		return policy
+	}
+
+	return DefaultPolicy()
+}
+
+// WithRecorder returns a copy of ctx where every failed attempt is recorded in recorder
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, recorder)
+}
+
+// Attempts returns a copy of the recorded failed attempts
+func (r *Recorder) Attempts() []Attempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts := make([]Attempt, len(r.attempts))
+	copy(attempts, r.attempts)
+
+	return attempts
+}
+
+func (r *Recorder) record(attempt Attempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts = append(r.attempts, attempt)
+}
+
+// IsRetryable reports whether err is a transient failure.
+// Operation timeouts are retryable, the run itself running out of time is handled by Do.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	return transientErrors.MatchString(err.Error())
+}
+
+// Do runs fn until it succeeds, returns a non retryable error or the policy stored in ctx runs out of attempts.
+// Every failed attempt is logged and recorded in the recorder stored in ctx, if any.
+func Do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	policy := PolicyFrom(ctx)
+	recorder, _ := ctx.Value(recorderKey{}).(*Recorder)
+	backoff := policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		retryable := IsRetryable(err) && ctx.Err() == nil
+		if recorder != nil {
+			recorder.record(Attempt{
+				Operation: operation,
+				Attempt:   attempt,
+				Error:     err.Error(),
+				Retryable: retryable,
+				Time:      time.Now(),
+			})
+		}
+
+		if !retryable || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		wait := withJitter(backoff, policy.Jitter)
+		metrics.From(ctx).Retry(operation)
+		logging.FromContext(ctx).Warn("operation failed, retrying", "operation", operation, "attempt", attempt, "backoff", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff = nextBackoff(backoff, policy)
+	}
+}
+
+// nextBackoff returns the backoff following backoff, capped by the maximum backoff of the policy
+func nextBackoff(backoff time.Duration, policy Policy) time.Duration {
+	backoff = time.Duration(float64(backoff) * policy.Multiplier)
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+
+	return backoff
+}
+
+func withJitter(backoff time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return backoff
+	}
+
+	delta := (rand.Float64()*2 - 1) * jitter * float64(backoff)
+
+	return backoff + time.Duration(delta)
+}
@@ -0,0 +1,164 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("Error: status is 503, identifier is '503', code is 'CLUSTERS-MGMT-503'"), true},
+		{errors.New("server returned status code: 429"), true},
+		{errors.New("502 Bad Gateway"), true},
+		{errors.New("504 gateway timeout"), true},
+		{errors.New("dial tcp 10.0.0.1:443: connect: connection refused"), true},
+		{errors.New("read tcp: connection reset by peer"), true},
+		{errors.New("net/http: TLS handshake timeout"), true},
+		{errors.New("dial tcp: lookup api.openshift.com: no such host"), true},
+		{errors.New("unexpected EOF"), true},
+		{fmt.Errorf("ocm get: %w", context.DeadlineExceeded), true},
+		{errors.New("Error: status is 401, identifier is '401'"), false},
+		{errors.New("Error: status is 404, identifier is '404'"), false},
+		{errors.New("status is 5030"), false},
+		{fmt.Errorf("ocm get: %w", context.Canceled), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	policy := Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	var backoffs []time.Duration
+	for backoff := policy.InitialBackoff; len(backoffs) < 5; backoff = nextBackoff(backoff, policy) {
+		backoffs = append(backoffs, backoff)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if backoffs[i] != want[i] {
+			t.Fatalf("backoffs = %v, want %v", backoffs, want)
+		}
+	}
+
+	policy.MaxBackoff = 0
+	if backoff := nextBackoff(8*time.Second, policy); backoff != 16*time.Second {
+		t.Errorf("uncapped backoff = %v, want 16s", backoff)
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	if wait := withJitter(time.Second, 0); wait != time.Second {
+		t.Errorf("wait without jitter = %v, want 1s", wait)
+	}
+
+	for i := 0; i < 100; i++ {
+		wait := withJitter(time.Second, 1)
+		if wait < 0 || wait > 2*time.Second {
+			t.Fatalf("wait = %v, want between 0 and 2s", wait)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, jitter := range []float64{0, 0.2, 1} {
+		policy := DefaultPolicy()
+		policy.Jitter = jitter
+		if err := policy.Validate(); err != nil {
+			t.Errorf("Validate() with jitter %v = %v, want nil", jitter, err)
+		}
+	}
+
+	for _, jitter := range []float64{-0.1, 1.5} {
+		policy := DefaultPolicy()
+		policy.Jitter = jitter
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate() with jitter %v succeeded, want an error", jitter)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	recorder := &Recorder{}
+	ctx := WithRecorder(WithPolicy(context.Background(), Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     2,
+	}), recorder)
+
+	calls := 0
+	err := Do(ctx, "ocm get /api/clusters_mgmt/v1/clusters", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("status is 503")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Do() = %v after %d calls, want success on the third attempt", err, calls)
+	}
+
+	calls = 0
+	err = Do(ctx, "obsctl login", func(ctx context.Context) error {
+		calls++
+		return errors.New("status is 401")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Do() = %v after %d calls, want a single attempt for a permanent error", err, calls)
+	}
+
+	calls = 0
+	err = Do(ctx, "obsctl metrics query", func(ctx context.Context) error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Do() = %v after %d calls, want the attempts to run out", err, calls)
+	}
+
+	attempts := recorder.Attempts()
+	if len(attempts) != 6 {
+		t.Fatalf("recorded %d attempts, want 6: %+v", len(attempts), attempts)
+	}
+	if attempts[0].Operation != "ocm get /api/clusters_mgmt/v1/clusters" || attempts[0].Attempt != 1 || !attempts[0].Retryable {
+		t.Errorf("first attempt = %+v, want a retryable ocm get", attempts[0])
+	}
+	if attempts[2].Operation != "obsctl login" || attempts[2].Retryable {
+		t.Errorf("login attempt = %+v, want a permanent failure", attempts[2])
+	}
+	if last := attempts[5]; last.Operation != "obsctl metrics query" || last.Attempt != 3 {
+		t.Errorf("last attempt = %+v, want the third query attempt", last)
+	}
+
+	// The returned attempts are a copy
+	attempts[0].Operation = "changed"
+	if recorder.Attempts()[0].Operation == "changed" {
+		t.Error("Attempts() returned the recorded attempts, want a copy")
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithPolicy(context.Background(), Policy{MaxAttempts: 5, InitialBackoff: time.Hour}))
+
+	calls := 0
+	err := Do(ctx, "ocm login", func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errors.New("status is 503")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Do() = %v after %d calls, want to stop once the run is cancelled", err, calls)
+	}
+}
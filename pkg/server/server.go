@@ -0,0 +1,480 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/history"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+	// StatusCanceled is the status of the runs still queued when the server shuts down
+	StatusCanceled = "canceled"
+)
+
+// ErrQueueFull is returned by Submit when every worker is busy and the queue is full
+var ErrQueueFull = errors.New("the run queue is full")
+
+// Config configures the server and the runs it executes
+type Config struct {
+	// Defaults are the options of every run, a request only sets its operator, imagetag, environment and selectors
+	Defaults workflows.Options
+	// Workers is the number of runs executed at once, QueueSize the number of runs waiting for a worker
+	Workers   int
+	QueueSize int
+	// Retain is the number of finished runs kept, the oldest ones are forgotten first
+	Retain int
+	// RunContext derives the context of a run from parent, applying the timeouts and the retry policy, when set
+	RunContext func(parent context.Context) (context.Context, context.CancelFunc)
+	// Logger returns the logger of a run writing to w, the runs are logged as text when it is nil
+	Logger func(w io.Writer) *slog.Logger
+	// Notifier returns the notifier of a new run, the runs are not notified when it or the notifier it returns is nil
+	Notifier func() (*notify.Notifier, error)
+	// History is the path of the run history the report of every run is added to when set, it is only opened while
+	// a report is added so the history command and the command line runs can use it while the server runs
+	History string
+	// Metrics records the metrics of every run and is exposed on GET /metrics when set
+	Metrics *metrics.Metrics
+	// Token is the bearer token the requests to /runs must carry, the runs are open to anyone when it is empty
+	Token string
+}
+
+// Request is the body of POST /runs
+type Request struct {
+	Operator    string   `json:"operator"`
+	ImageTag    string   `json:"imagetag"`
+	Environment string   `json:"environment"`
+	Selectors   []string `json:"selectors"`
+}
+
+// Run is the state of a run submitted to the server, as returned by GET /runs/{id}
+type Run struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	Request   Request        `json:"request"`
+	Submitted time.Time      `json:"submitted"`
+	Started   *time.Time     `json:"started,omitempty"`
+	Finished  *time.Time     `json:"finished,omitempty"`
+	ExitCode  *int           `json:"exit_code,omitempty"`
+	Report    *report.Report `json:"report,omitempty"`
+	log       *runLog
+}
+
+// Server executes the runs submitted over its HTTP API with a bounded pool of workers.
+// Every run gets its own options, logger and ocm and obsctl configuration directory,
+// the credentials are the ones of the server and never show up in its responses.
+type Server struct {
+	config Config
+	queue  chan *Run
+	// execute runs a run, Runner.Run unless the tests replace it
+	execute func(ctx context.Context, opts workflows.Options) (*report.Report, []error)
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu       sync.Mutex
+	runs     map[string]*Run
+	finished []string
+
+	historyMu sync.Mutex
+}
+
+// runLog is the log of a single run, written by its logger while it is read by GET /runs/{id}/log
+type runLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *runLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.buf.Write(p)
+}
+
+func (l *runLog) Bytes() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return bytes.Clone(l.buf.Bytes())
+}
+
+// New starts the workers of a server, Close stops them
+func New(config Config) *Server {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.QueueSize < 0 {
+		config.QueueSize = 0
+	}
+	if config.RunContext == nil {
+		config.RunContext = context.WithCancel
+	}
+	if config.Logger == nil {
+		config.Logger = func(w io.Writer) *slog.Logger {
+			return slog.New(slog.NewTextHandler(w, nil))
+		}
+	}
+
+	s := &Server{
+		config: config,
+		queue:  make(chan *Run, config.QueueSize),
+		runs:   map[string]*Run{},
+		execute: func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			return workflows.NewRunner(opts).Run(ctx)
+		},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < config.Workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+
+	return s
+}
+
+// Close cancels the running runs, marks the queued ones as canceled and waits for the workers to stop
+func (s *Server) Close() {
+	s.cancel()
+	s.workers.Wait()
+
+	for {
+		select {
+		case run := <-s.queue:
+			s.mu.Lock()
+			run.Status = StatusCanceled
+			s.mu.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+// Submit queues a run of the request, it fails with ErrQueueFull when the queue is full
+func (s *Server) Submit(request Request) (Run, error) {
+	err := request.validate()
+	if err != nil {
+		return Run{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Run{}, err
+	}
+
+	run := &Run{ID: id, Status: StatusQueued, Request: request, Submitted: time.Now().UTC(), log: &runLog{}}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.queue <- run:
+	default:
+		return Run{}, ErrQueueFull
+	}
+	s.runs[id] = run
+
+	return *run, nil
+}
+
+// Get returns a copy of the run, false when there is no such run
+func (s *Server) Get(id string) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+
+	return *run, true
+}
+
+// Log returns the log of the run, false when there is no such run
+func (s *Server) Log(id string) ([]byte, bool) {
+	run, ok := s.Get(id)
+	if !ok {
+		return nil, false
+	}
+
+	return run.log.Bytes(), true
+}
+
+func (s *Server) work() {
+	defer s.workers.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case run := <-s.queue:
+			if s.ctx.Err() != nil {
+				s.mu.Lock()
+				run.Status = StatusCanceled
+				s.mu.Unlock()
+				return
+			}
+			s.runOne(run)
+		}
+	}
+}
+
+// runOne executes a run with its own options, logger and configuration directory
+func (s *Server) runOne(run *Run) {
+	started := time.Now().UTC()
+	s.mu.Lock()
+	run.Status = StatusRunning
+	run.Started = &started
+	s.mu.Unlock()
+
+	runReport, errs := s.executeRun(run)
+
+	exitCode := workflows.ExitPassed
+	if len(errs) > 0 {
+		exitCode = workflows.ExitCode(errs)
+	}
+	finished := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.Status = StatusFinished
+	run.Finished = &finished
+	run.ExitCode = &exitCode
+	run.Report = runReport
+
+	s.finished = append(s.finished, run.ID)
+	for s.config.Retain > 0 && len(s.finished) > s.config.Retain {
+		delete(s.runs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+func (s *Server) executeRun(run *Run) (*report.Report, []error) {
+	logger := s.config.Logger(run.log).With("run_id", run.ID)
+
+	ctx, cancel := s.config.RunContext(s.ctx)
+	defer cancel()
+	ctx = logging.WithLogger(ctx, logger)
+	ctx = metrics.WithMetrics(ctx, s.config.Metrics)
+
+	opts := s.config.Defaults
+	opts.Operator = run.Request.Operator
+	opts.ImageTag = run.Request.ImageTag
+	opts.Environment = run.Request.Environment
+	opts.Selectors = run.Request.Selectors
+
+	// The ocm and obsctl logins of the run are kept in a directory of its own, removed once it is done
+	dir, err := os.MkdirTemp("", "acceptance_test-run-")
+	if err != nil {
+		err = fmt.Errorf("failed to create the configuration directory of the run: %w", err)
+		logger.Error("run failed", "error", err)
+		return nil, []error{fmt.Errorf("%w: %w", workflows.ErrInfrastructure, err)}
+	}
+	defer os.RemoveAll(dir)
+	env := []string{"OCM_CONFIG=" + filepath.Join(dir, "ocm.json"), "XDG_CONFIG_HOME=" + dir}
+	// The setup of the run reports an unknown environment
+	if backplaneFile, err := ocm.WriteBackplaneConfig(dir, opts.Environment); err == nil {
+		env = append(env, "BACKPLANE_CONFIG="+backplaneFile)
+	}
+	ctx = helpers.WithCommandEnv(ctx, env...)
+
+	if s.config.Notifier != nil {
+		notifier, err := s.config.Notifier()
+		if err != nil {
+			logger.Error("invalid notification settings, the run is not notified", "error", err)
+		}
+		ctx = notify.WithNotifier(ctx, notifier)
+	}
+
+	logger.Info("starting run", "operator", opts.Operator, "imagetag", opts.ImageTag, "environment", opts.Environment, "selectors", opts.Selectors)
+	runReport, errs := s.execute(ctx, opts)
+	logger.Info("run finished", "verdict", runReport.Verdict)
+
+	if s.config.History != "" {
+		id, err := s.storeRun(runReport)
+		if err != nil {
+			logger.Warn("the run is not stored in the history", "error", err)
+		} else {
+			logger.Info("run stored in the history", "history_id", id)
+		}
+	}
+
+	return runReport, errs
+}
+
+// storeRun adds the report of a run to the history, one worker at a time as each of them takes the lock of the file
+func (s *Server) storeRun(runReport *report.Report) (uint64, error) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	store, err := history.Open(s.config.History)
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	return store.Add(runReport)
+}
+
+// Handler returns the HTTP API of the server:
+// POST /runs queues a run, GET /runs/{id} returns its status and report and GET /runs/{id}/log its log.
+// GET /metrics exposes the metrics of the runs to Prometheus when the server records them.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runs", s.authorized(s.handleRuns))
+	mux.HandleFunc("/runs/", s.authorized(s.handleRun))
+	if s.config.Metrics != nil {
+		mux.Handle("/metrics", s.config.Metrics.Handler())
+	}
+
+	return mux
+}
+
+// authorized refuses the requests without the bearer token of the server, as the runs use its credentials
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	if s.config.Token == "" {
+		return handler
+	}
+
+	want := []byte("Bearer " + s.config.Token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("a valid bearer token is required"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	var request Request
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid run request: %v", err))
+		return
+	}
+
+	run, err := s.Submit(request)
+	if errors.Is(err, ErrQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	id, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	switch resource {
+	case "":
+		run, ok := s.Get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case "log":
+		log, ok := s.Log(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", id))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(log)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+func (r Request) validate() error {
+	var errs []string
+
+	if r.Operator == "" {
+		errs = append(errs, "operator is required")
+	}
+	if r.ImageTag == "" {
+		errs = append(errs, "imagetag is required")
+	}
+	if r.Environment == "" {
+		errs = append(errs, "environment is required")
+	}
+	if len(r.Selectors) == 0 {
+		errs = append(errs, "selectors are required")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid run request: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 8)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a run ID: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/assets"
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/history"
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+)
+
+const runRequest = `{"operator": "example-operator", "imagetag": "abc123", "environment": "stage", "selectors": ["us-east-1", "main"]}`
+
+// newTestServer returns a server whose runs are executed by execute instead of the workflows
+func newTestServer(t *testing.T, config Config, execute func(ctx context.Context, opts workflows.Options) (*report.Report, []error)) *httptest.Server {
+	t.Helper()
+
+	s := New(config)
+	s.execute = execute
+	t.Cleanup(s.Close)
+
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func submit(t *testing.T, server *httptest.Server, body string) (*http.Response, Run) {
+	t.Helper()
+	var run Run
+
+	response, err := http.Post(server.URL+"/runs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusAccepted {
+		err = json.NewDecoder(response.Body).Decode(&run)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return response, run
+}
+
+// waitFor polls the run until it finished
+func waitFor(t *testing.T, server *httptest.Server, id string) (Run, string) {
+	t.Helper()
+	var run Run
+
+	deadline := time.Now().Add(5 * time.Second)
+	for run.Status != StatusFinished {
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s did not finish, status %q", id, run.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		response, err := http.Get(server.URL + "/runs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("GET /runs/%s = %d: %s", id, response.StatusCode, body)
+		}
+
+		run = Run{}
+		err = json.Unmarshal(body, &run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(body), "server-ocm-token") {
+			t.Errorf("run status leaks the credentials: %s", body)
+		}
+	}
+
+	response, err := http.Get(server.URL + "/runs/" + id + "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	log, _ := io.ReadAll(response.Body)
+
+	return run, string(log)
+}
+
+func TestServerRun(t *testing.T) {
+	defaults := workflows.DefaultOptions()
+	defaults.Credentials.OCMToken = "server-ocm-token"
+
+	server := newTestServer(t, Config{Defaults: defaults, Workers: 2, QueueSize: 2, Metrics: metrics.New()},
+		func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			logging.FromContext(ctx).Info("checking clusters", "operator", opts.Operator)
+			metrics.From(ctx).ObserveRun(opts.Environment, workflows.ModeAcceptance, workflows.VerdictPassed, time.Second)
+
+			env := strings.Join(helpers.CommandEnv(ctx), " ")
+			if !strings.Contains(env, "OCM_CONFIG=") || !strings.Contains(env, "XDG_CONFIG_HOME=") {
+				t.Errorf("command env = %q, want a configuration directory of the run", env)
+			}
+			if opts.Credentials.OCMToken != "server-ocm-token" || opts.ImageTag != "abc123" || opts.Environment != "stage" {
+				t.Errorf("options = %+v, want the request on top of the defaults", opts)
+			}
+
+			return &report.Report{Operator: opts.Operator, ImageTag: opts.ImageTag, Verdict: workflows.VerdictPassed}, nil
+		})
+
+	response, run := submit(t, server, runRequest)
+	if response.StatusCode != http.StatusAccepted || run.ID == "" || response.Header.Get("Location") != "/runs/"+run.ID {
+		t.Fatalf("POST /runs = %d, location %q, run %+v", response.StatusCode, response.Header.Get("Location"), run)
+	}
+
+	run, log := waitFor(t, server, run.ID)
+	if run.ExitCode == nil || *run.ExitCode != workflows.ExitPassed || run.Report == nil || run.Report.Verdict != workflows.VerdictPassed {
+		t.Errorf("run = %+v, want a passed report", run)
+	}
+	if !strings.Contains(log, "checking clusters") || !strings.Contains(log, "run_id="+run.ID) {
+		t.Errorf("run log = %q, want the records of the run", log)
+	}
+
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	exposed, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(exposed), `acceptance_test_runs_total{environment="stage",mode="acceptance",verdict="PASSED"} 1`) {
+		t.Errorf("GET /metrics = %d:\n%s\nwant the run", response.StatusCode, exposed)
+	}
+}
+
+func TestServerConcurrentRuns(t *testing.T) {
+	processBackplaneConfig := os.Getenv("BACKPLANE_CONFIG")
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	dirs := map[string]string{}
+
+	server := newTestServer(t, Config{Workers: 2, QueueSize: 2},
+		func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			// Both runs are running before either of them checks its configuration
+			started <- struct{}{}
+			<-release
+
+			dir, _ := helpers.LookupCommandEnv(ctx, "XDG_CONFIG_HOME")
+			backplaneFile, ok := helpers.LookupCommandEnv(ctx, "BACKPLANE_CONFIG")
+			if !ok || filepath.Dir(backplaneFile) != dir {
+				t.Errorf("%s run: BACKPLANE_CONFIG = %q, want a file of the run directory %q", opts.Environment, backplaneFile, dir)
+			}
+			data, err := os.ReadFile(backplaneFile)
+			want, _ := assets.Assets.ReadFile("config." + opts.Environment + ".json")
+			if err != nil || string(data) != string(want) {
+				t.Errorf("%s run: backplane config = %q, %v, want the config of its environment", opts.Environment, data, err)
+			}
+			if os.Getenv("BACKPLANE_CONFIG") != processBackplaneConfig {
+				t.Errorf("%s run changed BACKPLANE_CONFIG of the process", opts.Environment)
+			}
+
+			mu.Lock()
+			dirs[opts.Environment] = dir
+			mu.Unlock()
+
+			return &report.Report{Environment: opts.Environment, Verdict: workflows.VerdictPassed}, nil
+		})
+
+	var ids []string
+	for _, environment := range []string{"stage", "prod"} {
+		response, run := submit(t, server, strings.Replace(runRequest, `"stage"`, `"`+environment+`"`, 1))
+		if response.StatusCode != http.StatusAccepted {
+			t.Fatalf("POST /runs = %d, want the %s run to be accepted", response.StatusCode, environment)
+		}
+		ids = append(ids, run.ID)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the runs were not executed at once")
+		}
+	}
+	close(release)
+
+	for _, id := range ids {
+		run, _ := waitFor(t, server, id)
+		if run.ExitCode == nil || *run.ExitCode != workflows.ExitPassed {
+			t.Errorf("run = %+v, want it to pass", run)
+		}
+	}
+	if dirs["stage"] == "" || dirs["stage"] == dirs["prod"] {
+		t.Errorf("configuration directories = %v, want one per run", dirs)
+	}
+}
+
+func TestServerHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	server := newTestServer(t, Config{Workers: 2, QueueSize: 2, History: path},
+		func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			// A command line run stores its report while the server runs
+			store, err := history.Open(path)
+			if err != nil {
+				t.Errorf("history.Open() while the server runs = %v", err)
+				return &report.Report{Verdict: workflows.VerdictErrored}, nil
+			}
+			defer store.Close()
+			_, err = store.Add(&report.Report{Operator: "cli-operator", Verdict: workflows.VerdictPassed})
+			if err != nil {
+				t.Error(err)
+			}
+
+			return &report.Report{Operator: opts.Operator, Verdict: workflows.VerdictPassed}, nil
+		})
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		_, run := submit(t, server, runRequest)
+		ids = append(ids, run.ID)
+	}
+	for _, id := range ids {
+		waitFor(t, server, id)
+	}
+
+	store, err := history.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	entries, err := store.List(history.Filter{Operator: "example-operator"})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("runs of the server in the history = %v, %v, want 2", entries, err)
+	}
+	entries, err = store.List(history.Filter{Operator: "cli-operator"})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("runs of the command line in the history = %v, %v, want 2", entries, err)
+	}
+}
+
+func TestServerRejectsRequests(t *testing.T) {
+	release := make(chan struct{})
+	server := newTestServer(t, Config{Workers: 1, QueueSize: 1},
+		func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			<-release
+			return &report.Report{Verdict: workflows.VerdictPassed}, nil
+		})
+	defer close(release)
+
+	for _, body := range []string{`{"operator": "example-operator"}`, `{"token": "abc"}`, `not json`} {
+		response, _ := submit(t, server, body)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST /runs %s = %d, want %d", body, response.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// One run is picked up by the worker and one waits in the queue
+	statuses := []int{}
+	for i := 0; i < 4; i++ {
+		response, _ := submit(t, server, runRequest)
+		statuses = append(statuses, response.StatusCode)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if statuses[0] != http.StatusAccepted || statuses[1] != http.StatusAccepted || statuses[3] != http.StatusServiceUnavailable {
+		t.Errorf("statuses = %v, want the runs over the workers and the queue to be refused", statuses)
+	}
+
+	for _, path := range []string{"/runs/unknown", "/runs/unknown/log"} {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", path, response.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	server := newTestServer(t, Config{Workers: 1, QueueSize: 1, Token: "api-token"},
+		func(ctx context.Context, opts workflows.Options) (*report.Report, []error) {
+			return &report.Report{Verdict: workflows.VerdictPassed}, nil
+		})
+
+	request := func(method, path, authorization string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(runRequest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		return response.StatusCode
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong-token", "api-token", "Basic YXBpLXRva2Vu"} {
+		if status := request(http.MethodPost, "/runs", authorization); status != http.StatusUnauthorized {
+			t.Errorf("POST /runs with %q = %d, want %d", authorization, status, http.StatusUnauthorized)
+		}
+		if status := request(http.MethodGet, "/runs/unknown", authorization); status != http.StatusUnauthorized {
+			t.Errorf("GET /runs/unknown with %q = %d, want %d", authorization, status, http.StatusUnauthorized)
+		}
+	}
+
+	if status := request(http.MethodPost, "/runs", "Bearer api-token"); status != http.StatusAccepted {
+		t.Errorf("POST /runs with the token = %d, want %d", status, http.StatusAccepted)
+	}
+	if status := request(http.MethodGet, "/runs/unknown", "Bearer api-token"); status != http.StatusNotFound {
+		t.Errorf("GET /runs/unknown with the token = %d, want %d", status, http.StatusNotFound)
+	}
+}
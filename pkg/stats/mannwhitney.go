@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MannWhitneyResult is the outcome of a two-sided Mann-Whitney U test between two samples
+type MannWhitneyResult struct {
+	// U is the U statistic of the first sample
+	U float64
+	// PValue is the two-sided p-value computed with the normal approximation, corrected for ties
+	PValue float64
+	// Effect is the probability that a value of the first sample is greater than a value of the second,
+	// counting ties as half. 0.5 means neither sample tends to be larger.
+	Effect float64
+}
+
+// MannWhitneyU runs a two-sided Mann-Whitney U test, a non-parametric test telling whether
+// one of the samples tends to have larger values than the other.
+func MannWhitneyU(x, y []float64) (MannWhitneyResult, error) {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 == 0 || n2 == 0 {
+		return MannWhitneyResult{}, fmt.Errorf("both samples need at least one value, got %d and %d", len(x), len(y))
+	}
+
+	type observation struct {
+		value float64
+		first bool
+	}
+
+	observations := make([]observation, 0, len(x)+len(y))
+	for _, value := range x {
+		observations = append(observations, observation{value, true})
+	}
+	for _, value := range y {
+		observations = append(observations, observation{value, false})
+	}
+	sort.Slice(observations, func(i, j int) bool { return observations[i].value < observations[j].value })
+
+	// Rank the observations, tied values get the average of the ranks they span
+	var rankSum, tieCorrection float64
+	for i := 0; i < len(observations); {
+		j := i
+		for j < len(observations) && observations[j].value == observations[i].value {
+			j++
+		}
+
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if observations[k].first {
+				rankSum += rank
+			}
+		}
+
+		ties := float64(j - i)
+		tieCorrection += ties*ties*ties - ties
+		i = j
+	}
+
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	result := MannWhitneyResult{U: u, PValue: 1, Effect: u / (n1 * n2)}
+
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1))))
+	if sigma == 0 {
+		// Every value is the same, there is nothing to tell the samples apart
+		return result, nil
+	}
+
+	// Continuity correction towards the mean
+	delta := u - mean
+	if delta > 0 {
+		delta = math.Max(delta-0.5, 0)
+	} else {
+		delta = math.Min(delta+0.5, 0)
+	}
+
+	z := delta / sigma
+	result.PValue = math.Min(1, math.Erfc(math.Abs(z)/math.Sqrt2))
+
+	return result, nil
+}
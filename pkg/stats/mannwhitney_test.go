@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+// The expected values were computed independently by counting the pairs of the samples, ties as half a pair,
+// with the tie corrected normal approximation and a continuity correction of 0.5
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name   string
+		x, y   []float64
+		u      float64
+		pValue float64
+		effect float64
+	}{
+		{
+			name:   "ties across samples of different sizes",
+			x:      []float64{12, 4, 8, 3, 12, 7, 10, 6},
+			y:      []float64{16, 5, 13, 7, 16, 6, 11, 14, 16},
+			u:      18,
+			pValue: 0.0907971095,
+			effect: 0.25,
+		},
+		{
+			name:   "swapped samples",
+			x:      []float64{16, 5, 13, 7, 16, 6, 11, 14, 16},
+			y:      []float64{12, 4, 8, 3, 12, 7, 10, 6},
+			u:      54,
+			pValue: 0.0907971095,
+			effect: 0.75,
+		},
+		{
+			name:   "complete separation",
+			x:      []float64{4, 5, 6},
+			y:      []float64{1, 2, 3},
+			u:      9,
+			pValue: 0.0808555984,
+			effect: 1,
+		},
+		{
+			name:   "no ties",
+			x:      []float64{1.5, 3.25, 7, 9.5, 12},
+			y:      []float64{2, 4.75},
+			u:      7,
+			pValue: 0.5612758361,
+			effect: 0.7,
+		},
+		{
+			name:   "many ties",
+			x:      []float64{0, 0, 1, 1, 2},
+			y:      []float64{1, 1, 2, 2, 3, 3},
+			u:      5,
+			pValue: 0.0716731291,
+			effect: 1.0 / 6,
+		},
+		{
+			name:   "all equal",
+			x:      []float64{2, 2, 2},
+			y:      []float64{2, 2},
+			u:      3,
+			pValue: 1,
+			effect: 0.5,
+		},
+		{
+			name:   "single values",
+			x:      []float64{1},
+			y:      []float64{2},
+			u:      0,
+			pValue: 1,
+			effect: 0,
+		},
+		{
+			name:   "tiny samples",
+			x:      []float64{3, 4},
+			y:      []float64{1},
+			u:      2,
+			pValue: 0.5402913746,
+			effect: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := MannWhitneyU(tt.x, tt.y)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.U != tt.u {
+				t.Errorf("U = %v, want %v", result.U, tt.u)
+			}
+			if math.Abs(result.PValue-tt.pValue) > 1e-9 {
+				t.Errorf("p-value = %.10f, want %.10f", result.PValue, tt.pValue)
+			}
+			if math.Abs(result.Effect-tt.effect) > 1e-9 {
+				t.Errorf("effect = %v, want %v", result.Effect, tt.effect)
+			}
+		})
+	}
+}
+
+func TestMannWhitneyUEmptySample(t *testing.T) {
+	_, err := MannWhitneyU([]float64{1, 2}, nil)
+	if err == nil {
+		t.Error("MannWhitneyU() with an empty sample succeeded, want an error")
+	}
+}
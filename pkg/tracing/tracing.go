@@ -0,0 +1,216 @@
+// Package tracing records OpenTelemetry spans of the runs: the setup, the discovery of the clusters, their checks,
+// every OCM call and Telemeter query and the cleanup. The spans are exported with the OTLP/HTTP and stdout exporters
+// of OpenTelemetry, scrubbed of the secrets of the run.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the scope of the spans of the acceptance test
+const instrumentationName = "github.com/MrSantamaria/acceptance_test"
+
+// tracesPath is the path the OTLP/HTTP collectors receive the spans on
+const tracesPath = "/v1/traces"
+
+// Config tells where the spans are exported, they are not recorded at all when both are empty
+type Config struct {
+	// Endpoint is the base URL of an OTLP/HTTP collector, e.g. http://localhost:4318
+	Endpoint string
+	// File is the path of a file the spans are appended to as JSON, one span per line
+	File string
+	// Secrets are replaced with REDACTED wherever they appear in the names, attributes, events and statuses of the spans
+	Secrets []string
+}
+
+// Setup installs the global tracer provider exporting the spans as configured and returns the function flushing
+// and stopping it. Until it is called the spans are not recorded.
+func Setup(config Config) (func(ctx context.Context) error, error) {
+	var exporters []sdktrace.SpanExporter
+
+	if config.Endpoint == "" && config.File == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if config.Endpoint != "" {
+		exporter, err := newHTTPExporter(config.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	if config.File != "" {
+		exporter, err := newFileExporter(config.File)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	scrubber := helpers.NewScrubber(config.Secrets...)
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("acceptance_test"))),
+	}
+	for _, exporter := range exporters {
+		options = append(options, sdktrace.WithBatcher(&redactingExporter{SpanExporter: exporter, scrubber: scrubber}))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newHTTPExporter returns the exporter posting the spans to the OTLP/HTTP collector whose base URL is endpoint
+func newHTTPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, want a URL such as http://localhost:4318", endpoint)
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), tracesPath) + tracesPath),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// fileExporter appends the spans to the trace file as JSON, one span per line, and closes it on shutdown
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the trace file: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the trace file: %w", err)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileExporter{Exporter: exporter, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.file.Close())
+}
+
+// Start starts a span named name as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed with err, unless it is nil, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// redactingExporter scrubs the secrets from the spans before handing them to the exporter
+type redactingExporter struct {
+	sdktrace.SpanExporter
+	scrubber helpers.Scrubber
+}
+
+func (e *redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if e.scrubber.Empty() {
+		return e.SpanExporter.ExportSpans(ctx, spans)
+	}
+
+	redacted := make([]sdktrace.ReadOnlySpan, 0, len(spans))
+	for _, span := range spans {
+		redacted = append(redacted, redactedSpan{ReadOnlySpan: span, scrubber: e.scrubber})
+	}
+
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan is a span whose free text, e.g. error messages carrying the output of ocm and obsctl, is scrubbed
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	scrubber helpers.Scrubber
+}
+
+func (s redactedSpan) Name() string {
+	return s.scrubber.Scrub(s.ReadOnlySpan.Name())
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue {
+	return s.scrubAttributes(s.ReadOnlySpan.Attributes())
+}
+
+func (s redactedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+
+	redacted := make([]sdktrace.Event, 0, len(events))
+	for _, event := range events {
+		event.Name = s.scrubber.Scrub(event.Name)
+		event.Attributes = s.scrubAttributes(event.Attributes)
+		redacted = append(redacted, event)
+	}
+
+	return redacted
+}
+
+func (s redactedSpan) Status() sdktrace.Status {
+	status := s.ReadOnlySpan.Status()
+	status.Description = s.scrubber.Scrub(status.Description)
+
+	return status
+}
+
+func (s redactedSpan) scrubAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	redacted := make([]attribute.KeyValue, 0, len(attrs))
+
+	for _, attr := range attrs {
+		switch attr.Value.Type() {
+		case attribute.STRING:
+			attr.Value = attribute.StringValue(s.scrubber.Scrub(attr.Value.AsString()))
+		case attribute.STRINGSLICE:
+			values := attr.Value.AsStringSlice()
+			for i := range values {
+				values[i] = s.scrubber.Scrub(values[i])
+			}
+			attr.Value = attribute.StringSliceValue(values)
+		}
+		redacted = append(redacted, attr)
+	}
+
+	return redacted
+}
@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const secret = "s3cr3t-ocm-token"
+
+// exportedSpan is the part of an exported span the tests check, whatever the exporter
+type exportedSpan struct {
+	scope    string
+	traceID  string
+	spanID   string
+	parentID string
+	failed   bool
+	message  string
+	events   []string
+}
+
+// fileSpan is a span of the trace file as written by the stdout exporter
+type fileSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ SpanID string }
+	Status      struct{ Code, Description string }
+	Events      []struct{ Name string }
+
+	InstrumentationLibrary struct{ Name string }
+}
+
+// record starts a run span with a failed ocm call under it, the way the workflows do
+func record(t *testing.T, config Config) {
+	t.Helper()
+
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	stop, err := Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, run := Start(context.Background(), "Run", attribute.String("operator", "hypershift-operator"))
+	_, call := Start(ctx, "ocm get", attribute.String("ocm.path", "/api/clusters_mgmt/v1/clusters"),
+		attribute.StringSlice("ocm.parameters", []string{"search=name like 'hs-mc-%'", "token=" + secret}))
+	End(call, errors.New("ocm failed with token "+secret))
+	End(run, nil)
+
+	err = stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSpans(t *testing.T, raw string, byName map[string]exportedSpan) {
+	t.Helper()
+
+	if strings.Contains(raw, secret) {
+		t.Errorf("the exported spans contain the secret:\n%s", raw)
+	}
+	if !strings.Contains(raw, helpers.Redacted) {
+		t.Errorf("the exported spans do not contain %s:\n%s", helpers.Redacted, raw)
+	}
+	if !strings.Contains(raw, "service.name") {
+		t.Errorf("the exported spans do not name the service:\n%s", raw)
+	}
+
+	run, ok := byName["Run"]
+	if !ok {
+		t.Fatalf("the Run span is not exported: %v", byName)
+	}
+	call, ok := byName["ocm get"]
+	if !ok {
+		t.Fatalf("the ocm get span is not exported: %v", byName)
+	}
+	for name, span := range byName {
+		if span.scope != instrumentationName {
+			t.Errorf("%s scope = %q, want %q", name, span.scope, instrumentationName)
+		}
+	}
+	if run.parentID != "" || call.parentID != run.spanID || call.traceID != run.traceID {
+		t.Errorf("ocm get is not a child of Run: run = %+v, call = %+v", run, call)
+	}
+	if run.failed {
+		t.Errorf("Run = %+v, want it not to fail", run)
+	}
+	if !call.failed || call.message != "ocm failed with token "+helpers.Redacted {
+		t.Errorf("ocm get = %+v, want a redacted error", call)
+	}
+	if len(call.events) != 1 || call.events[0] != "exception" {
+		t.Errorf("ocm get events = %v, want the error", call.events)
+	}
+}
+
+func TestSetupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces", "run.jsonl")
+
+	record(t, Config{File: path, Secrets: []string{secret, "short"}})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var raw strings.Builder
+	byName := map[string]exportedSpan{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span fileSpan
+		err = json.Unmarshal(scanner.Bytes(), &span)
+		if err != nil {
+			t.Fatalf("line %q is not a span: %v", scanner.Text(), err)
+		}
+		raw.Write(scanner.Bytes())
+
+		parentID := span.Parent.SpanID
+		if strings.Trim(parentID, "0") == "" {
+			parentID = ""
+		}
+		exported := exportedSpan{
+			scope:    span.InstrumentationLibrary.Name,
+			traceID:  span.SpanContext.TraceID,
+			spanID:   span.SpanContext.SpanID,
+			parentID: parentID,
+			failed:   span.Status.Code == "Error",
+			message:  span.Status.Description,
+		}
+		for _, event := range span.Events {
+			exported.events = append(exported.events, event.Name)
+		}
+		byName[span.Name] = exported
+	}
+
+	checkSpans(t, raw.String(), byName)
+}
+
+func TestSetupEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	var raw strings.Builder
+	byName := map[string]exportedSpan{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != tracesPath || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("export = %s %s %s, want a protobuf post to %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), tracesPath)
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		var request collectortracepb.ExportTraceServiceRequest
+		err := proto.Unmarshal(data, &request)
+		if err != nil {
+			t.Errorf("body is not an export request: %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		raw.Write(data)
+		for _, resource := range request.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, span := range scope.Spans {
+					exported := exportedSpan{
+						scope:    scope.Scope.GetName(),
+						traceID:  hex.EncodeToString(span.TraceId),
+						spanID:   hex.EncodeToString(span.SpanId),
+						parentID: hex.EncodeToString(span.ParentSpanId),
+						failed:   span.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR,
+						message:  span.Status.GetMessage(),
+					}
+					for _, event := range span.Events {
+						exported.events = append(exported.events, event.Name)
+					}
+					byName[span.Name] = exported
+				}
+			}
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	record(t, Config{Endpoint: collector.URL + "/", Secrets: []string{secret}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	checkSpans(t, raw.String(), byName)
+}
+
+func TestSetupInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "grpc://localhost:4317", "http://"} {
+		_, err := Setup(Config{Endpoint: endpoint})
+		if err == nil {
+			t.Errorf("Setup() with the endpoint %q succeeded, want an error", endpoint)
+		}
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	stop, err := Setup(Config{Secrets: []string{secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an exporter the spans are not recorded
+	_, span := Start(context.Background(), "Run")
+	if span.IsRecording() {
+		t.Error("span is recording, want the spans to be dropped")
+	}
+	End(span, errors.New("failed"))
+
+	err = stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,179 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"text/template"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"golang.org/x/exp/slog"
+)
+
+// Signal is a per-cluster health signal compared between the baseline and the new operator version.
+// Query is a text/template rendered with a signalQueryData, it must evaluate to a number where higher is worse.
+type Signal struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// signalQueryData is the data the signal queries are rendered with.
+// Offset is empty for the new version and shifts the query back in time for the baseline.
+type signalQueryData struct {
+	ClusterID string
+	Operator  string
+	ImageTag  string
+	Window    string
+	Offset    string
+}
+
+var defaultSignals = []Signal{
+	{
+		Name:  "abnormal",
+		Query: `csv_abnormal{_id="{{.ClusterID}}", name=~"{{.Operator}}.*{{.ImageTag}}"}[{{.Window}}]{{.Offset}}`,
+	},
+	{
+		Name:  "restarts",
+		Query: `sum(increase(kube_pod_container_status_restarts_total{_id="{{.ClusterID}}", namespace=~".*{{.Operator}}.*"}[{{.Window}}]{{.Offset}}))`,
+	},
+	{
+		Name:  "reconcile_errors",
+		Query: `sum(increase(controller_runtime_reconcile_errors_total{_id="{{.ClusterID}}", namespace=~".*{{.Operator}}.*"}[{{.Window}}]{{.Offset}}))`,
+	},
+}
+
+// loadSignals returns the signals listed in the JSON file at path, or the default ones when path is empty
+func loadSignals(path string) ([]Signal, error) {
+	if path == "" {
+		return defaultSignals, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signals: %v", err)
+	}
+
+	var signals []Signal
+	err = json.Unmarshal(data, &signals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signals: %v", err)
+	}
+
+	return signals, nil
+}
+
+// baselineSignals returns the signals compared with the baseline, none when there is no baseline imagetag
+func (r *Runner) baselineSignals() ([]Signal, error) {
+	if r.opts.Baseline.ImageTag == "" {
+		return nil, nil
+	}
+
+	signals, err := loadSignals(r.opts.Baseline.SignalsFile)
+	if err != nil {
+		return nil, classify(ErrConfiguration, err)
+	}
+
+	return signals, nil
+}
+
+// compareWithBaseline evaluates every health signal for the new imagetag and for the baseline imagetag,
+// the latter over a window shifted back by the baseline offset, and flags the signals which regressed
+// by more than the baseline tolerance.
+func (r *Runner) compareWithBaseline(ctx context.Context, logger *slog.Logger, clusterID string, signals []Signal) ([]report.Comparison, error) {
+	var comparisons []report.Comparison
+	var err error
+
+	current, baseline := r.baselineQueryData(clusterID)
+
+	for _, signal := range signals {
+		comparison := report.Comparison{Signal: signal.Name}
+
+		comparison.CurrentQuery, comparison.Current, comparison.CurrentSamples, err = r.evaluateSignal(ctx, signal, current)
+		if err != nil {
+			return comparisons, err
+		}
+
+		comparison.BaselineQuery, comparison.Baseline, comparison.BaselineSamples, err = r.evaluateSignal(ctx, signal, baseline)
+		if err != nil {
+			return comparisons, err
+		}
+
+		comparison.Regressed = regressed(comparison.Baseline, comparison.Current, r.opts.Baseline.Tolerance)
+		if comparison.Regressed {
+			logger.Error("signal regressed compared to the baseline",
+				"signal", signal.Name, "baseline", comparison.Baseline, "current", comparison.Current)
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	return comparisons, nil
+}
+
+// baselineQueryData returns the data the signal queries are rendered with for the new imagetag and for the baseline one
+func (r *Runner) baselineQueryData(clusterID string) (signalQueryData, signalQueryData) {
+	current := signalQueryData{
+		ClusterID: clusterID,
+		Operator:  r.opts.Operator,
+		ImageTag:  r.opts.ImageTag,
+		Window:    r.opts.SearchWindow,
+	}
+	baseline := current
+	baseline.ImageTag = r.opts.Baseline.ImageTag
+	baseline.Offset = " offset " + r.opts.Baseline.Offset
+
+	return current, baseline
+}
+
+// evaluateSignal returns the query of the signal rendered with data, its value and the series it was computed from
+func (r *Runner) evaluateSignal(ctx context.Context, signal Signal, data signalQueryData) (string, float64, []report.Series, error) {
+	query, err := renderSignal(signal, data)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	searchResults, err := telemeter.ObsctlSearchQuery(ctx, query)
+	if err != nil {
+		return query, 0, nil, classifyBackendError(err)
+	}
+
+	value, err := telemeter.ObsctlSumLatestValues(searchResults)
+	if err != nil {
+		return query, 0, nil, classify(ErrInfrastructure, err)
+	}
+
+	if !r.opts.Samples {
+		return query, value, nil, nil
+	}
+
+	series, err := telemeter.ObsctlSeries(searchResults)
+
+	return query, value, reportSeries(ctx, series, err), nil
+}
+
+// renderSignal renders the query of signal with data
+func renderSignal(signal Signal, data signalQueryData) (string, error) {
+	var query bytes.Buffer
+
+	tmpl, err := template.New(signal.Name).Parse(signal.Query)
+	if err != nil {
+		return "", classify(ErrConfiguration, fmt.Errorf("invalid query for signal %s: %v", signal.Name, err))
+	}
+
+	err = tmpl.Execute(&query, data)
+	if err != nil {
+		return "", classify(ErrConfiguration, fmt.Errorf("invalid query for signal %s: %v", signal.Name, err))
+	}
+
+	return query.String(), nil
+}
+
+// regressed reports whether current grew past baseline by more than the tolerance, a fraction of the baseline.
+// A baseline of zero is treated as one so that a signal going from nothing to a handful of events is caught.
+func regressed(baseline, current, tolerance float64) bool {
+	return current-baseline > tolerance*math.Max(baseline, 1)
+}
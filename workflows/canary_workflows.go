@@ -0,0 +1,198 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/stats"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	ModeAcceptance = "acceptance"
+	ModeCanary     = "canary"
+
+	CanaryPass         = "pass"
+	CanaryMarginal     = "marginal"
+	CanaryFail         = "fail"
+	CanaryInconclusive = "inconclusive"
+)
+
+/*
+1. We will gather the clusters matching the selectors, like the acceptance test does
+2. We will split them between the clusters already running the new imagetag and the ones still on the old version
+3. We will evaluate every metric on every cluster and compare both populations with a Mann-Whitney U test
+4. We will score the run with the share of metrics which did not get worse on the new version
+*/
+func (r *Runner) CanaryAnalysis(ctx context.Context) (*report.CanaryAnalysis, error) {
+	logger := logging.FromContext(ctx).With("phase", "canary")
+	analysis := &report.CanaryAnalysis{}
+
+	clusters, err := r.resolveClusters(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusterExternalIDs []string
+	for _, cluster := range clusters {
+		if cluster.ExternalID != "" {
+			clusterExternalIDs = append(clusterExternalIDs, cluster.ExternalID)
+		}
+	}
+
+	analysis.NewClusters, analysis.OldClusters, err = r.splitPopulations(ctx, logger, clusterExternalIDs)
+	if err != nil {
+		return analysis, err
+	}
+
+	minClusters := r.opts.Canary.MinClusters
+	if len(analysis.NewClusters) < minClusters || len(analysis.OldClusters) < minClusters {
+		analysis.Classification = CanaryInconclusive
+		return analysis, classify(ErrInconclusive, fmt.Errorf("canary analysis needs at least %d clusters on each version, got %d new and %d old",
+			minClusters, len(analysis.NewClusters), len(analysis.OldClusters)))
+	}
+
+	metrics, err := loadSignals(r.opts.Canary.MetricsFile)
+	if err != nil {
+		return analysis, classify(ErrConfiguration, err)
+	}
+
+	var passed, marginal float64
+	for _, metric := range metrics {
+		metricAnalysis, err := r.analyzeMetric(ctx, metric, analysis.NewClusters, analysis.OldClusters)
+		if err != nil {
+			return analysis, err
+		}
+		logger.Info("analyzed canary metric",
+			"metric", metric.Name, "p_value", metricAnalysis.PValue, "effect", metricAnalysis.Effect, "classification", metricAnalysis.Classification)
+
+		switch metricAnalysis.Classification {
+		case CanaryPass:
+			passed++
+		case CanaryMarginal:
+			marginal++
+		case CanaryFail:
+			notify.Fail(ctx, notify.Failure{Reason: fmt.Sprintf("%s got worse on the new version, p-value %.4f", metric.Name, metricAnalysis.PValue)})
+		}
+		analysis.Metrics = append(analysis.Metrics, metricAnalysis)
+	}
+
+	if len(analysis.Metrics) > 0 {
+		analysis.Score = 100 * (passed + marginal/2) / float64(len(analysis.Metrics))
+	}
+
+	switch {
+	case analysis.Score >= r.opts.Canary.PassScore:
+		analysis.Classification = CanaryPass
+	case analysis.Score >= r.opts.Canary.MarginalScore:
+		analysis.Classification = CanaryMarginal
+	default:
+		analysis.Classification = CanaryFail
+	}
+	logger.Info("Canary Analysis "+analysis.Classification, "score", analysis.Score,
+		"new_clusters", len(analysis.NewClusters), "old_clusters", len(analysis.OldClusters))
+
+	switch analysis.Classification {
+	case CanaryFail:
+		return analysis, classify(ErrAcceptance, fmt.Errorf("canary score %.1f is below the marginal score", analysis.Score))
+	case CanaryMarginal:
+		return analysis, classify(ErrInconclusive, fmt.Errorf("canary score %.1f is marginal", analysis.Score))
+	}
+
+	return analysis, nil
+}
+
+// splitPopulations sorts the clusters by the operator version they are running.
+// Clusters where the operator CSV did not succeed on either version are left out of the analysis.
+func (r *Runner) splitPopulations(ctx context.Context, logger *slog.Logger, clusterIDs []string) ([]string, []string, error) {
+	var newClusters, oldClusters []string
+
+	for _, clusterID := range clusterIDs {
+		onNew, err := r.runCheck(ctx, "csv_succeeded", r.csvQuery("csv_succeeded", clusterID), func(series int) bool { return series > 0 })
+		if err != nil {
+			return newClusters, oldClusters, err
+		}
+		if onNew.Passed {
+			newClusters = append(newClusters, clusterID)
+			continue
+		}
+
+		onOld, err := r.runCheck(ctx, "csv_succeeded", r.oldVersionCSVQuery(clusterID), func(series int) bool { return series > 0 })
+		if err != nil {
+			return newClusters, oldClusters, err
+		}
+		if onOld.Passed {
+			oldClusters = append(oldClusters, clusterID)
+			continue
+		}
+
+		logger.Warn("operator CSV did not succeed on any version, leaving the cluster out of the canary analysis", "external_id", clusterID)
+	}
+
+	return newClusters, oldClusters, nil
+}
+
+// oldVersionCSVQuery returns the query finding the operator CSV of the baseline imagetag, or of any other imagetag
+// than the new one when there is no baseline, succeeding on the cluster
+func (r *Runner) oldVersionCSVQuery(clusterID string) string {
+	operator := r.opts.Operator
+	oldVersionMatcher := "name=~\"" + operator + ".*\", name!~\"" + operator + ".*" + r.opts.ImageTag + "\""
+	if baseline := r.opts.Baseline.ImageTag; baseline != "" {
+		oldVersionMatcher = "name=~\"" + operator + ".*" + baseline + "\""
+	}
+
+	return "csv_succeeded{_id=\"" + clusterID + "\", " + oldVersionMatcher + "}[" + r.opts.SearchWindow + "]"
+}
+
+// analyzeMetric evaluates metric on both populations and classifies the difference.
+// Metrics are expected to be higher when worse, only a significant increase on the new version counts against it.
+func (r *Runner) analyzeMetric(ctx context.Context, metric Signal, newClusters, oldClusters []string) (report.MetricAnalysis, error) {
+	metricAnalysis := report.MetricAnalysis{Metric: metric.Name}
+
+	data := signalQueryData{
+		Operator: r.opts.Operator,
+		ImageTag: r.opts.ImageTag,
+		Window:   r.opts.SearchWindow,
+	}
+	for _, clusterID := range newClusters {
+		data.ClusterID = clusterID
+		_, value, _, err := r.evaluateSignal(ctx, metric, data)
+		if err != nil {
+			return metricAnalysis, err
+		}
+		metricAnalysis.NewValues = append(metricAnalysis.NewValues, value)
+	}
+
+	data.ImageTag = r.opts.Baseline.ImageTag
+	for _, clusterID := range oldClusters {
+		data.ClusterID = clusterID
+		_, value, _, err := r.evaluateSignal(ctx, metric, data)
+		if err != nil {
+			return metricAnalysis, err
+		}
+		metricAnalysis.OldValues = append(metricAnalysis.OldValues, value)
+	}
+
+	result, err := stats.MannWhitneyU(metricAnalysis.NewValues, metricAnalysis.OldValues)
+	if err != nil {
+		return metricAnalysis, classify(ErrInconclusive, err)
+	}
+	metricAnalysis.U = result.U
+	metricAnalysis.PValue = result.PValue
+	metricAnalysis.Effect = result.Effect
+
+	worse := result.Effect > 0.5
+	switch {
+	case worse && result.PValue < r.opts.Canary.Alpha:
+		metricAnalysis.Classification = CanaryFail
+	case worse && result.PValue < r.opts.Canary.MarginalAlpha:
+		metricAnalysis.Classification = CanaryMarginal
+	default:
+		metricAnalysis.Classification = CanaryPass
+	}
+
+	return metricAnalysis, nil
+}
@@ -0,0 +1,198 @@
+package workflows_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter/telemeterfake"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+	"github.com/prometheus/common/model"
+)
+
+// Versions the canary fleet clusters run: the first four the new one, the next four the baseline one
+// and the last one an older version, on neither side of the analysis when the baseline is set
+var canaryVersions = []string{
+	"v0.2.0-abc123", "v0.2.0-abc123", "v0.2.0-abc123", "v0.2.0-abc123",
+	"v0.1.0-old456", "v0.1.0-old456", "v0.1.0-old456", "v0.1.0-old456",
+	"v0.0.9-older789",
+}
+
+// writeCanaryFleet writes a fake OCM fleet of one management cluster per canary version, all matching the
+// us-east-1 and main selectors, and returns its directory along with the external IDs of the clusters
+func writeCanaryFleet(t *testing.T) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	err := os.Mkdir(filepath.Join(dir, "clusters"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var items []map[string]interface{}
+	var externalIDs []string
+	for i := range canaryVersions {
+		clusterID := fmt.Sprintf("canary%d", i)
+		externalID := fmt.Sprintf("external-canary-%d", i)
+		externalIDs = append(externalIDs, externalID)
+
+		items = append(items, map[string]interface{}{
+			"id":             fmt.Sprintf("mc-canary-%d", i),
+			"kind":           "ManagementCluster",
+			"href":           fmt.Sprintf("/api/osd_fleet_mgmt/v1/management_clusters/mc-canary-%d", i),
+			"name":           fmt.Sprintf("hs-mc-canary-%d", i),
+			"status":         "ready",
+			"cloud_provider": "aws",
+			"region":         "us-east-1",
+			"sector":         "main",
+			"cluster_management_reference": map[string]string{
+				"cluster_id": clusterID,
+				"href":       "/api/clusters_mgmt/v1/clusters/" + clusterID,
+			},
+		})
+		writeJSON(t, filepath.Join(dir, "clusters", clusterID+".json"), map[string]string{
+			"kind":        "Cluster",
+			"id":          clusterID,
+			"href":        "/api/clusters_mgmt/v1/clusters/" + clusterID,
+			"name":        fmt.Sprintf("hs-mc-canary-%d", i),
+			"external_id": externalID,
+			"state":       "ready",
+		})
+	}
+	writeJSON(t, filepath.Join(dir, "management_clusters.json"), map[string]interface{}{"kind": "ManagementClusterList", "items": items})
+	writeJSON(t, filepath.Join(dir, "service_clusters.json"), map[string]interface{}{"kind": "ServiceClusterList", "items": []interface{}{}})
+
+	return dir, externalIDs
+}
+
+func writeJSON(t *testing.T, path string, value interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCanaryAnalysis(t *testing.T) {
+	ago := model.Duration(4 * time.Minute)
+	tests := []struct {
+		name           string
+		baseline       string
+		minClusters    int
+		metrics        map[string][]float64 // values of the metrics on the canary fleet clusters, in order
+		exitCode       int
+		classification string
+		score          float64
+		oldClusters    int
+	}{
+		{
+			name:           "no metric got worse",
+			baseline:       "old456",
+			metrics:        map[string][]float64{"stable": {1, 1, 1, 1, 1, 1, 1, 1, 1}},
+			exitCode:       workflows.ExitPassed,
+			classification: workflows.CanaryPass,
+			score:          100,
+			oldClusters:    4,
+		},
+		{
+			name:     "one metric got slightly worse",
+			baseline: "old456",
+			metrics: map[string][]float64{
+				"stable": {1, 1, 1, 1, 1, 1, 1, 1, 1},
+				"slower": {4, 5, 6, 7, 1, 2, 3, 4.5, 0},
+			},
+			exitCode:       workflows.ExitInconclusive,
+			classification: workflows.CanaryMarginal,
+			score:          75,
+			oldClusters:    4,
+		},
+		{
+			name:           "metric got worse",
+			baseline:       "old456",
+			metrics:        map[string][]float64{"errors": {5, 6, 7, 8, 1, 2, 3, 4, 0}},
+			exitCode:       workflows.ExitFailed,
+			classification: workflows.CanaryFail,
+			score:          0,
+			oldClusters:    4,
+		},
+		{
+			name:           "any other imagetag is the old version without a baseline",
+			metrics:        map[string][]float64{"stable": {1, 1, 1, 1, 1, 1, 1, 1, 1}},
+			exitCode:       workflows.ExitPassed,
+			classification: workflows.CanaryPass,
+			score:          100,
+			oldClusters:    5,
+		},
+		{
+			name:           "too few clusters on the old version",
+			baseline:       "old456",
+			minClusters:    5,
+			metrics:        map[string][]float64{"stable": {1, 1, 1, 1, 1, 1, 1, 1, 1}},
+			exitCode:       workflows.ExitInconclusive,
+			classification: workflows.CanaryInconclusive,
+			oldClusters:    4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fleetDir, externalIDs := writeCanaryFleet(t)
+			runner, server := setUpFleet(t, fleetDir)
+
+			var signals []workflows.Signal
+			for i, externalID := range externalIDs {
+				server.AddSeries(telemeterfake.Series{
+					Labels:  map[string]string{"__name__": "csv_succeeded", "_id": externalID, "name": "example-operator." + canaryVersions[i]},
+					Samples: []telemeterfake.Sample{{Ago: ago, Value: 1}},
+				})
+				for metric, values := range tt.metrics {
+					server.AddSeries(telemeterfake.Series{
+						Labels:  map[string]string{"__name__": metric, "_id": externalID},
+						Samples: []telemeterfake.Sample{{Ago: ago, Value: values[i]}},
+					})
+				}
+			}
+			for metric := range tt.metrics {
+				signals = append(signals, workflows.Signal{Name: metric, Query: metric + `{_id="{{.ClusterID}}"}[{{.Window}}]`})
+			}
+			metricsFile := filepath.Join(t.TempDir(), "metrics.json")
+			writeJSON(t, metricsFile, signals)
+
+			options := runner.Options()
+			options.Baseline.ImageTag = tt.baseline
+			options.Canary.MetricsFile = metricsFile
+			if tt.minClusters != 0 {
+				options.Canary.MinClusters = tt.minClusters
+			}
+			runner = workflows.NewRunner(options)
+
+			analysis, err := runner.CanaryAnalysis(testContext())
+			var errs []error
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if exitCode := workflows.ExitCode(errs); exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d, error: %v", exitCode, tt.exitCode, err)
+			}
+
+			if analysis.Classification != tt.classification || analysis.Score != tt.score {
+				t.Errorf("analysis = %s with score %v, want %s with score %v", analysis.Classification, analysis.Score, tt.classification, tt.score)
+			}
+			if strings.Join(analysis.NewClusters, ",") != strings.Join(externalIDs[:4], ",") {
+				t.Errorf("new clusters = %v, want %v", analysis.NewClusters, externalIDs[:4])
+			}
+			if strings.Join(analysis.OldClusters, ",") != strings.Join(externalIDs[4:4+tt.oldClusters], ",") {
+				t.Errorf("old clusters = %v, want %v", analysis.OldClusters, externalIDs[4:4+tt.oldClusters])
+			}
+		})
+	}
+}
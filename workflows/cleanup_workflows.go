@@ -1,18 +1,33 @@
 package workflows
 
 import (
-	"fmt"
+	"context"
 
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
 	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
-	"github.com/spf13/viper"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
 )
 
-func CleanUp() error {
+// CleanUp logs out of Telemeter
+func (r *Runner) CleanUp(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "CleanUp")
+	err := r.cleanUp(ctx)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *Runner) cleanUp(ctx context.Context) error {
+	if replay.Replaying(ctx) {
+		return nil
+	}
+
 	// TODO: Update how this function is called once the telemeter config is pointer based
-	err := telemeter.ObsctlLogout(telemeter.SetObsctlConfig(viper.GetString("environment")))
+	err := telemeter.ObsctlLogout(ctx, telemeter.SetObsctlConfig(r.opts.Environment))
 	if err != nil {
-		fmt.Println("ERROR: Failed to logout local Telemeter instance")
-		return err
+		logging.FromContext(ctx).Error("Failed to logout local Telemeter instance", "phase", "cleanup", "error", err)
+		return classify(ErrInfrastructure, err)
 	}
 
 	return nil
@@ -0,0 +1,31 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+)
+
+// ListSectors logs in to OCM, unless the run is replayed, and returns the sectors found in the fleet listing with their cluster counts
+func (r *Runner) ListSectors(ctx context.Context) (helpers.SectorSet, error) {
+	if !replay.Replaying(ctx) {
+		if !ocm.CliCheck(ctx) {
+			return nil, classify(ErrConfiguration, fmt.Errorf("ocm-cli is not installed"))
+		}
+
+		err := ocm.Login(ctx, r.opts.Credentials.OCMToken, r.opts.Environment, r.opts.OCMURL)
+		if err != nil {
+			return nil, classifyBackendError(err)
+		}
+	}
+
+	sectors, err := ocm.GetSectors(ctx)
+	if err != nil {
+		return nil, classifyBackendError(err)
+	}
+
+	return sectors, nil
+}
@@ -0,0 +1,61 @@
+package workflows
+
+import (
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// CoveragePolicyFail fails the run when too few clusters were verified
+	CoveragePolicyFail = "fail"
+	// CoveragePolicyWarn only logs a warning when too few clusters were verified
+	CoveragePolicyWarn = "warn"
+)
+
+// evaluateCoverage counts the clusters which reached a PASSED or FAILED verdict, per kind,
+// and checks them against the minimum number of clusters and the minimum share of the selected fleet.
+// Clusters which could not be checked already explain the missing coverage, failing the coverage on top of them
+// would report an outage or clusters without telemetry as a failed promotion. The shortfall is only logged when a
+// cluster ERRORED and is INCONCLUSIVE, whatever the inconclusive policy, when a cluster was INCONCLUSIVE.
+func (r *Runner) evaluateCoverage(logger *slog.Logger, results []report.ClusterResult) (*report.Coverage, error) {
+	coverage := &report.Coverage{Selected: len(results), ByKind: map[string]report.KindCoverage{}}
+	var errored, inconclusive bool
+
+	for _, result := range results {
+		errored = errored || result.Verdict == VerdictErrored
+		inconclusive = inconclusive || result.Verdict == VerdictInconclusive
+		kindCoverage := coverage.ByKind[result.Kind]
+		kindCoverage.Selected++
+		if result.Verdict == VerdictPassed || result.Verdict == VerdictFailed {
+			kindCoverage.Verified++
+			coverage.Verified++
+		}
+		coverage.ByKind[result.Kind] = kindCoverage
+	}
+
+	if coverage.Selected > 0 {
+		coverage.Percent = 100 * float64(coverage.Verified) / float64(coverage.Selected)
+	}
+	logger.Info("coverage", "selected", coverage.Selected, "verified", coverage.Verified, "percent", coverage.Percent, "by_kind", coverage.ByKind)
+
+	minClusters := r.opts.MinClusters
+	minCoverage := r.opts.MinCoverage
+	if coverage.Verified >= minClusters && coverage.Percent >= minCoverage {
+		return coverage, nil
+	}
+
+	err := fmt.Errorf("only %d of %d selected clusters (%.1f%%) were verified, at least %d clusters and %.1f%% are required",
+		coverage.Verified, coverage.Selected, coverage.Percent, minClusters, minCoverage)
+	if r.opts.CoveragePolicy == CoveragePolicyWarn || errored {
+		logger.Warn("coverage is below the threshold", "error", err)
+		return coverage, nil
+	}
+	if inconclusive {
+		logger.Warn("coverage is below the threshold, clusters without telemetry could not be verified", "error", err)
+		return coverage, classify(ErrInconclusive, err)
+	}
+
+	return coverage, classify(ErrAcceptance, err)
+}
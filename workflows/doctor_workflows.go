@@ -0,0 +1,171 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
+)
+
+const (
+	PreflightPassed  = "PASS"
+	PreflightFailed  = "FAIL"
+	PreflightSkipped = "SKIP"
+)
+
+// Preflight is the outcome of one of the checks run by Doctor
+type Preflight struct {
+	Name   string
+	Status string
+	Detail string
+	// Hint tells how to fix the check when it failed
+	Hint string
+
+	err error
+}
+
+// preflights runs checks one after the other, a check is skipped when one of the checks it depends on did not pass
+type preflights struct {
+	results []Preflight
+	passed  map[string]bool
+}
+
+func (p *preflights) run(name string, dependsOn []string, check func() (string, string, error)) {
+	for _, dependency := range dependsOn {
+		if !p.passed[dependency] {
+			p.results = append(p.results, Preflight{Name: name, Status: PreflightSkipped, Detail: "skipped, " + dependency + " did not pass"})
+			return
+		}
+	}
+
+	detail, hint, err := check()
+	if err != nil {
+		p.results = append(p.results, Preflight{Name: name, Status: PreflightFailed, Detail: err.Error(), Hint: hint, err: err})
+		return
+	}
+
+	p.passed[name] = true
+	p.results = append(p.results, Preflight{Name: name, Status: PreflightPassed, Detail: detail})
+}
+
+// Doctor runs every preflight check of a run on its own, without running the acceptance test, so a misconfigured
+// environment is diagnosed in one go. The returned error joins the classified errors of the failed checks.
+func (r *Runner) Doctor(ctx context.Context) ([]Preflight, error) {
+	p := &preflights{passed: map[string]bool{}}
+
+	p.run("ocm binary", nil, func() (string, string, error) {
+		return toolCheck("ocm", "go install github.com/openshift-online/ocm-cli/cmd/ocm@latest")
+	})
+
+	p.run("obsctl binary", nil, func() (string, string, error) {
+		return toolCheck("obsctl", "go install github.com/observatorium/obsctl@main")
+	})
+
+	p.run("configuration", nil, func() (string, string, error) {
+		err := r.validateRequiredVars(false)
+		if err != nil {
+			return "", "pass the missing or invalid flags, see --help", classify(ErrConfiguration, err)
+		}
+
+		return fmt.Sprintf("operator %s, imagetag %s, selectors %v", r.opts.Operator, r.opts.ImageTag, r.opts.Selectors), "", nil
+	})
+
+	p.run("OCM token", nil, func() (string, string, error) {
+		if r.opts.Credentials.OCMToken == "" {
+			return "", "pass --token with an OCM token of the environment", classify(ErrConfiguration, fmt.Errorf("ocm token is not set"))
+		}
+
+		return "set", "", nil
+	})
+
+	p.run("Telemeter credentials", nil, func() (string, string, error) {
+		var missing []string
+
+		if r.opts.Credentials.TelemeterClientID == "" {
+			missing = append(missing, "TELEMETER_CLIENT_ID")
+		}
+		if r.opts.Credentials.TelemeterSecret == "" {
+			missing = append(missing, "TELEMETER_SECRET")
+		}
+		if len(missing) > 0 {
+			return "", "set TELEMETER_CLIENT_ID and TELEMETER_SECRET, or pass --telemeterClientID and --telemeterSecret",
+				classify(ErrConfiguration, fmt.Errorf("%v not set", missing))
+		}
+
+		return "client ID and secret set", "", nil
+	})
+
+	p.run("backplane config", nil, func() (string, string, error) {
+		backplaneURL, err := ocm.CheckBackplaneConfig(r.opts.Environment)
+		if err != nil {
+			return "", "pass --env int, stage or prod", classify(ErrConfiguration, err)
+		}
+
+		return "backplane API " + backplaneURL, "", nil
+	})
+
+	p.run("OCM login", []string{"ocm binary", "OCM token", "backplane config"}, func() (string, string, error) {
+		apiURL := ocm.APIURL(r.opts.Environment, r.opts.OCMURL)
+
+		err := ocm.Login(ctx, r.opts.Credentials.OCMToken, r.opts.Environment, r.opts.OCMURL)
+		if err != nil {
+			return "", "check " + apiURL + " is reachable and the token is valid for it, tokens expire", classifyBackendError(err)
+		}
+
+		return "logged in to " + apiURL, "", nil
+	})
+
+	p.run("Observatorium token", []string{"Telemeter credentials"}, func() (string, string, error) {
+		telemeterConfig := telemeter.SetObsctlConfig(r.opts.Environment)
+
+		_, err := telemeter.OidcToken(ctx, telemeterConfig.OidcIssuerURL, telemeterConfig.OidcAudience,
+			r.opts.Credentials.TelemeterClientID, r.opts.Credentials.TelemeterSecret)
+		if err != nil {
+			return "", "check the Telemeter client is allowed the " + telemeterConfig.OidcAudience + " audience at " + telemeterConfig.OidcIssuerURL,
+				classify(ErrInfrastructure, err)
+		}
+
+		return "token issued for " + telemeterConfig.OidcAudience, "", nil
+	})
+
+	p.run("selectors", []string{"configuration", "OCM login"}, func() (string, string, error) {
+		clusters, err := ocm.GetManagementAndServiceClusters(ctx, r.opts.Selectors, r.opts.Sectors)
+		if err != nil {
+			return "", "list the sectors of the fleet with the clusters sectors command", classifyBackendError(err)
+		}
+
+		kinds := map[string]int{}
+		for _, cluster := range clusters {
+			kinds[cluster.Kind]++
+		}
+		if len(clusters) == 0 {
+			return "", "the selectors must name an AWS region and a sector holding clusters",
+				classify(ErrConfiguration, fmt.Errorf("selectors %v did not match any cluster", r.opts.Selectors))
+		}
+
+		return fmt.Sprintf("%d management and %d service clusters selected", kinds[ocm.ManagementClusterKind], kinds[ocm.ServiceClusterKind]), "", nil
+	})
+
+	var errs []error
+	for _, result := range p.results {
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.err))
+		}
+	}
+
+	return p.results, errors.Join(errs...)
+}
+
+// toolCheck finds the named tool and its version, install is the hint given when it is missing
+func toolCheck(name, install string) (string, string, error) {
+	toolPath, version, err := helpers.ToolVersion(name)
+	if err != nil {
+		return "", "install it with " + install + ", or run a binary built with bundled tools with --bundled-tools",
+			classify(ErrConfiguration, fmt.Errorf("%s is not installed", name))
+	}
+
+	return fmt.Sprintf("version %s at %s", version, toolPath), "", nil
+}
@@ -0,0 +1,143 @@
+package workflows_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+)
+
+// fakeTools puts stand-ins of the named tools, which succeed whatever their arguments, alone on the PATH
+func fakeTools(t *testing.T, names ...string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	for _, name := range names {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\nexit 0\n"), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestDoctor(t *testing.T) {
+	// The Observatorium token check asks the SSO of the environment for a token, it is only run where it fails
+	// without reaching it
+	tests := []struct {
+		name        string
+		tools       []string
+		environment string
+		selectors   []string
+		telemeter   bool
+		statuses    map[string]string
+		hints       map[string]string
+	}{
+		{
+			name:        "configured environment",
+			tools:       []string{"ocm", "obsctl"},
+			environment: "stage",
+			selectors:   []string{"us-east-1", "main"},
+			statuses: map[string]string{
+				"ocm binary":            workflows.PreflightPassed,
+				"obsctl binary":         workflows.PreflightPassed,
+				"configuration":         workflows.PreflightPassed,
+				"OCM token":             workflows.PreflightPassed,
+				"Telemeter credentials": workflows.PreflightFailed,
+				"backplane config":      workflows.PreflightPassed,
+				"OCM login":             workflows.PreflightPassed,
+				"Observatorium token":   workflows.PreflightSkipped,
+				"selectors":             workflows.PreflightPassed,
+			},
+			hints: map[string]string{"Telemeter credentials": "set TELEMETER_CLIENT_ID and TELEMETER_SECRET"},
+		},
+		{
+			name:        "missing tools",
+			environment: "stage",
+			selectors:   []string{"us-east-1", "main"},
+			statuses: map[string]string{
+				"ocm binary":       workflows.PreflightFailed,
+				"obsctl binary":    workflows.PreflightFailed,
+				"backplane config": workflows.PreflightPassed,
+				"OCM login":        workflows.PreflightSkipped,
+				"selectors":        workflows.PreflightSkipped,
+			},
+			hints: map[string]string{
+				"ocm binary":    "install it with go install github.com/openshift-online/ocm-cli/cmd/ocm@latest",
+				"obsctl binary": "install it with go install github.com/observatorium/obsctl@main",
+			},
+		},
+		{
+			name:        "unknown environment",
+			tools:       []string{"ocm", "obsctl"},
+			environment: "dev",
+			selectors:   []string{"us-east-1", "main"},
+			telemeter:   true,
+			statuses: map[string]string{
+				"Telemeter credentials": workflows.PreflightPassed,
+				"backplane config":      workflows.PreflightFailed,
+				"OCM login":             workflows.PreflightSkipped,
+				"Observatorium token":   workflows.PreflightFailed,
+				"selectors":             workflows.PreflightSkipped,
+			},
+			hints: map[string]string{"backplane config": "pass --env int, stage or prod"},
+		},
+		{
+			name:        "selectors without clusters",
+			tools:       []string{"ocm", "obsctl"},
+			environment: "stage",
+			selectors:   []string{"eu-west-1", "main"},
+			statuses: map[string]string{
+				"OCM login": workflows.PreflightPassed,
+				"selectors": workflows.PreflightFailed,
+			},
+			hints: map[string]string{"selectors": "the selectors must name an AWS region and a sector holding clusters"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := setUpFakes(t)
+			fakeTools(t, tt.tools...)
+
+			options := runner.Options()
+			options.Environment = tt.environment
+			options.Selectors = tt.selectors
+			options.Credentials.OCMToken = "token"
+			if tt.telemeter {
+				options.Credentials.TelemeterClientID = "client"
+				options.Credentials.TelemeterSecret = "secret"
+			}
+			runner = workflows.NewRunner(options)
+
+			// The ocm login reads the backplane config from the command environment instead of the working directory
+			ctx := helpers.WithCommandEnv(testContext(), "BACKPLANE_CONFIG="+filepath.Join(t.TempDir(), "backplane.json"))
+			results, err := runner.Doctor(ctx)
+			if exitCode := workflows.ExitCode([]error{err}); exitCode != workflows.ExitConfiguration {
+				t.Fatalf("exit code = %d, want %d, error: %v", exitCode, workflows.ExitConfiguration, err)
+			}
+
+			checked := map[string]workflows.Preflight{}
+			for _, result := range results {
+				checked[result.Name] = result
+			}
+			for name, status := range tt.statuses {
+				result, ok := checked[name]
+				if !ok || result.Status != status {
+					t.Errorf("%s = %+v, want %s", name, result, status)
+				}
+			}
+			for name, hint := range tt.hints {
+				if result := checked[name]; !strings.Contains(result.Hint, hint) {
+					t.Errorf("%s hint = %q, want it to contain %q", name, result.Hint, hint)
+				}
+			}
+			if skipped := checked["OCM login"]; skipped.Status == workflows.PreflightSkipped && !strings.HasSuffix(skipped.Detail, " did not pass") {
+				t.Errorf("OCM login detail = %q, want the check it depends on", skipped.Detail)
+			}
+		})
+	}
+}
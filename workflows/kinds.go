@@ -0,0 +1,138 @@
+package workflows
+
+import (
+	"fmt"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+)
+
+// Checks which can be enabled per kind of cluster
+const (
+	CheckLiveness     = "liveness"
+	CheckCSVSucceeded = "csv_succeeded"
+	CheckCSVAbnormal  = "csv_abnormal"
+	CheckBaseline     = "baseline"
+)
+
+// kindFlags maps the kind names used on the command line to the fleet manager cluster kinds
+var kindFlags = map[string]string{
+	"management": ocm.ManagementClusterKind,
+	"service":    ocm.ServiceClusterKind,
+}
+
+var validChecks = map[string]bool{
+	CheckLiveness:     true,
+	CheckCSVSucceeded: true,
+	CheckCSVAbnormal:  true,
+	CheckBaseline:     true,
+}
+
+// verdictSeverity orders the verdicts from the least to the most severe
+var verdictSeverity = map[string]int{
+	VerdictPassed:       0,
+	VerdictInconclusive: 1,
+	VerdictErrored:      2,
+	VerdictFailed:       3,
+}
+
+// expectedKinds returns the fleet manager kinds of the clusters the operator is expected to run on
+func (r *Runner) expectedKinds() map[string]bool {
+	kinds := map[string]bool{}
+	for _, kind := range r.opts.OperatorKinds {
+		kinds[kindFlags[kind]] = true
+	}
+
+	return kinds
+}
+
+// checksForKind returns the checks enabled for the given fleet manager kind
+func (r *Runner) checksForKind(kind string) map[string]bool {
+	var checks []string
+	switch kind {
+	case ocm.ManagementClusterKind:
+		checks = r.opts.ManagementChecks
+	case ocm.ServiceClusterKind:
+		checks = r.opts.ServiceChecks
+	}
+
+	enabled := map[string]bool{}
+	for _, check := range checks {
+		enabled[check] = true
+	}
+
+	return enabled
+}
+
+// validateKinds checks the operator kinds and the checks configured for each kind
+func (r *Runner) validateKinds() []error {
+	var errs []error
+
+	if len(r.opts.OperatorKinds) == 0 {
+		errs = append(errs, fmt.Errorf("operator-kinds must list at least one kind"))
+	}
+	for _, kind := range r.opts.OperatorKinds {
+		if _, ok := kindFlags[kind]; !ok {
+			errs = append(errs, fmt.Errorf("operator-kinds contains %q, valid kinds are management and service", kind))
+		}
+	}
+
+	for _, checks := range [][]string{r.opts.ManagementChecks, r.opts.ServiceChecks} {
+		for _, check := range checks {
+			if !validChecks[check] {
+				errs = append(errs, fmt.Errorf("unknown check %q, valid checks are %s, %s, %s and %s", check, CheckLiveness, CheckCSVSucceeded, CheckCSVAbnormal, CheckBaseline))
+			}
+		}
+	}
+
+	// A cluster without any check would pass without a single query and count as verified
+	if r.opts.Mode != ModeCanary {
+		for _, name := range r.opts.OperatorKinds {
+			kind, ok := kindFlags[name]
+			if !ok {
+				continue
+			}
+
+			checks := r.checksForKind(kind)
+			if r.opts.Baseline.ImageTag == "" {
+				delete(checks, CheckBaseline)
+			}
+			if len(checks) == 0 {
+				errs = append(errs, fmt.Errorf("%s clusters would not be checked, %s-checks must list a check other than %s or baseline-imagetag must be set",
+					name, name, CheckBaseline))
+			}
+		}
+	}
+
+	return errs
+}
+
+// groupByKind groups the cluster results per kind, in the order the kinds were first seen,
+// and gives every kind the most severe verdict of its clusters.
+func (r *Runner) groupByKind(results []report.ClusterResult) []report.KindResult {
+	var kinds []report.KindResult
+	index := map[string]int{}
+
+	for _, result := range results {
+		i, ok := index[result.Kind]
+		if !ok {
+			i = len(kinds)
+			index[result.Kind] = i
+
+			var checks []string
+			for _, check := range []string{CheckLiveness, CheckCSVSucceeded, CheckCSVAbnormal, CheckBaseline} {
+				if r.checksForKind(result.Kind)[check] {
+					checks = append(checks, check)
+				}
+			}
+			kinds = append(kinds, report.KindResult{Kind: result.Kind, Checks: checks, Verdict: VerdictPassed})
+		}
+
+		kinds[i].Clusters = append(kinds[i].Clusters, result)
+		if verdictSeverity[result.Verdict] > verdictSeverity[kinds[i].Verdict] {
+			kinds[i].Verdict = result.Verdict
+		}
+	}
+
+	return kinds
+}
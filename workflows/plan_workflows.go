@@ -0,0 +1,214 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+)
+
+// Plan is what a run would check: the clusters matching the selectors and every query it would send to Telemeter
+type Plan struct {
+	Options Options
+	// Inventory is the fleet inventory file the clusters were selected from, taken at InventoryTime
+	Inventory     string
+	InventoryTime time.Time
+	Clusters      []PlannedCluster
+}
+
+// PlannedCluster is a cluster a run would check along with its queries
+type PlannedCluster struct {
+	ClusterID  string
+	Kind       string
+	ExternalID string
+	Queries    []PlannedQuery
+}
+
+// PlannedQuery is a PromQL query a run would send to Telemeter
+type PlannedQuery struct {
+	Check string
+	Query string
+	// Window describes the time range the query looks at
+	Window string
+}
+
+// Plan selects the clusters from the fleet inventory file of the options, or from the inventory cached by the last run,
+// and lists the queries the run would send for each of them. It neither needs credentials nor reaches OCM or Telemeter.
+func (r *Runner) Plan(ctx context.Context) (*Plan, error) {
+	plan := &Plan{Options: r.opts, Inventory: r.opts.Inventory}
+	plan.Options.Credentials = Credentials{}
+
+	err := r.validateRequiredVars(false)
+	if err != nil {
+		return nil, classify(ErrConfiguration, err)
+	}
+
+	if plan.Inventory == "" {
+		if r.opts.InventoryCache == "" {
+			return nil, classify(ErrConfiguration, fmt.Errorf("a dry run needs an inventory file or the inventory cache"))
+		}
+		plan.Inventory = r.inventoryCachePath()
+	}
+
+	inventory, err := ocm.LoadInventory(plan.Inventory)
+	if errors.Is(err, fs.ErrNotExist) && r.opts.Inventory == "" {
+		return nil, classify(ErrConfiguration, fmt.Errorf("no inventory is cached for the %s environment yet, pass an inventory file or run once to cache it", r.opts.Environment))
+	}
+	if err != nil {
+		return nil, classify(ErrConfiguration, err)
+	}
+	plan.InventoryTime = inventory.Time
+
+	selected, err := inventory.Select(ctx, r.opts.Selectors, r.opts.Sectors)
+	if err != nil {
+		return nil, classifyBackendError(err)
+	}
+
+	// The canary analysis uses the baseline imagetag as the old version, not the baseline signals
+	var signals []Signal
+	if r.opts.Mode != ModeCanary {
+		signals, err = r.baselineSignals()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kinds := r.expectedKinds()
+	for _, cluster := range selected {
+		if !kinds[cluster.Kind] {
+			continue
+		}
+
+		planned := PlannedCluster{ClusterID: cluster.ClusterID, Kind: cluster.Kind, ExternalID: cluster.ExternalID}
+		// The queries are still worth showing when the inventory does not know the external ID
+		externalID := cluster.ExternalID
+		if externalID == "" {
+			externalID = "<external ID of " + cluster.ClusterID + ">"
+		}
+
+		if r.opts.Mode == ModeCanary {
+			planned.Queries, err = r.canaryQueries(externalID)
+		} else {
+			planned.Queries, err = r.acceptanceQueries(cluster.Kind, externalID, signals)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Clusters = append(plan.Clusters, planned)
+	}
+
+	return plan, nil
+}
+
+// acceptanceQueries lists the queries the acceptance test sends for a cluster, in the order they are sent
+func (r *Runner) acceptanceQueries(kind, clusterID string, signals []Signal) ([]PlannedQuery, error) {
+	var queries []PlannedQuery
+
+	checks := r.checksForKind(kind)
+	window := "last " + r.opts.SearchWindow
+
+	if checks[CheckLiveness] {
+		queries = append(queries, PlannedQuery{Check: CheckLiveness, Query: r.livenessQuery(clusterID), Window: window})
+	}
+	if checks[CheckCSVSucceeded] {
+		queries = append(queries, PlannedQuery{Check: CheckCSVSucceeded, Query: r.csvQuery("csv_succeeded", clusterID), Window: window})
+	}
+	if checks[CheckCSVAbnormal] {
+		queries = append(queries, PlannedQuery{Check: CheckCSVAbnormal, Query: r.csvQuery("csv_abnormal", clusterID), Window: window})
+	}
+
+	if checks[CheckBaseline] && r.opts.Baseline.ImageTag != "" {
+		current, baseline := r.baselineQueryData(clusterID)
+		for _, signal := range signals {
+			currentQuery, err := renderSignal(signal, current)
+			if err != nil {
+				return nil, err
+			}
+			baselineQuery, err := renderSignal(signal, baseline)
+			if err != nil {
+				return nil, err
+			}
+
+			queries = append(queries,
+				PlannedQuery{Check: CheckBaseline + " " + signal.Name, Query: currentQuery, Window: window},
+				PlannedQuery{Check: CheckBaseline + " " + signal.Name + " on " + r.opts.Baseline.ImageTag, Query: baselineQuery,
+					Window: r.opts.SearchWindow + " ending " + r.opts.Baseline.Offset + " ago"})
+		}
+	}
+
+	return queries, nil
+}
+
+// canaryQueries lists the queries the canary analysis may send for a cluster. Which version the cluster runs is only
+// known once the population queries were answered, so the metric queries of both versions are listed.
+func (r *Runner) canaryQueries(clusterID string) ([]PlannedQuery, error) {
+	window := "last " + r.opts.SearchWindow
+	queries := []PlannedQuery{
+		{Check: "population new", Query: r.csvQuery("csv_succeeded", clusterID), Window: window},
+		{Check: "population old", Query: r.oldVersionCSVQuery(clusterID), Window: window},
+	}
+
+	metrics, err := loadSignals(r.opts.Canary.MetricsFile)
+	if err != nil {
+		return nil, classify(ErrConfiguration, err)
+	}
+
+	data := signalQueryData{ClusterID: clusterID, Operator: r.opts.Operator, ImageTag: r.opts.ImageTag, Window: r.opts.SearchWindow}
+	oldData := data
+	oldData.ImageTag = r.opts.Baseline.ImageTag
+	for _, metric := range metrics {
+		newQuery, err := renderSignal(metric, data)
+		if err != nil {
+			return nil, err
+		}
+		oldQuery, err := renderSignal(metric, oldData)
+		if err != nil {
+			return nil, err
+		}
+
+		queries = append(queries,
+			PlannedQuery{Check: metric.Name + " when new", Query: newQuery, Window: window},
+			PlannedQuery{Check: metric.Name + " when old", Query: oldQuery, Window: window})
+	}
+
+	return queries, nil
+}
+
+// Write prints the plan, the credentials of the options are left out
+func (p *Plan) Write(w io.Writer) error {
+	var b strings.Builder
+
+	opts := p.Options
+	fmt.Fprintf(&b, "Dry run of the %s of %s:%s in the %s environment\n", opts.Mode, opts.Operator, opts.ImageTag, opts.Environment)
+	fmt.Fprintf(&b, "  selectors: %v, sectors: %v\n", opts.Selectors, opts.Sectors)
+	fmt.Fprintf(&b, "  operator kinds: %v, management checks: %v, service checks: %v\n", opts.OperatorKinds, opts.ManagementChecks, opts.ServiceChecks)
+	fmt.Fprintf(&b, "  search window: %s, inconclusive policy: %s\n", opts.SearchWindow, opts.InconclusivePolicy)
+	fmt.Fprintf(&b, "  coverage: at least %d clusters and %.1f%% verified, policy %s\n", opts.MinClusters, opts.MinCoverage, opts.CoveragePolicy)
+	if opts.Baseline.ImageTag != "" {
+		fmt.Fprintf(&b, "  baseline: %s %s earlier, tolerance %.2f\n", opts.Baseline.ImageTag, opts.Baseline.Offset, opts.Baseline.Tolerance)
+	}
+	fmt.Fprintf(&b, "Clusters from %s, taken %s:\n", p.Inventory, p.InventoryTime.Format(time.RFC3339))
+
+	if len(p.Clusters) == 0 {
+		fmt.Fprintf(&b, "  no cluster matches the selectors\n")
+	}
+	for _, cluster := range p.Clusters {
+		externalID := cluster.ExternalID
+		if externalID == "" {
+			externalID = "unknown"
+		}
+		fmt.Fprintf(&b, "  %s %s (external ID %s)\n", cluster.Kind, cluster.ClusterID, externalID)
+		for _, query := range cluster.Queries {
+			fmt.Fprintf(&b, "    %s, %s:\n      %s\n", query.Check, query.Window, query.Query)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
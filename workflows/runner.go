@@ -0,0 +1,254 @@
+package workflows
+
+import (
+	"context"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// cleanUpTimeout bounds the cleanup, which still runs once the context of the run is done
+const cleanUpTimeout = 5 * time.Minute
+
+// Options is the configuration of a run, every workflow of a Runner reads its settings from it
+type Options struct {
+	Operator    string
+	ImageTag    string
+	Environment string
+	// Selectors are the AWS region and the sector of the clusters to check
+	Selectors []string
+	// Sectors optionally restricts the sectors the selectors may use
+	Sectors []string
+	// SearchWindow is the PromQL range the Telemeter queries look back over, e.g. 10m
+	SearchWindow string
+	Credentials  Credentials
+	// OCMURL overrides the OCM API URL of the environment when set
+	OCMURL string
+	// Inventory is the fleet inventory file a dry run plans with, the cached inventory is used when it is empty
+	Inventory string
+	// InventoryCache is the directory where every run caches the fleet inventory of its environment, caching is disabled when empty
+	InventoryCache string
+	// Samples keeps the samples returned by the queries in the report, they are only plotted by the HTML report
+	Samples bool
+
+	LivenessMetric     string
+	InconclusivePolicy string
+
+	OperatorKinds    []string
+	ManagementChecks []string
+	ServiceChecks    []string
+
+	MinClusters    int
+	MinCoverage    float64
+	CoveragePolicy string
+
+	Mode     string
+	Baseline BaselineOptions
+	Canary   CanaryOptions
+}
+
+// Credentials are the secrets used to log in to OCM and Telemeter
+type Credentials struct {
+	OCMToken          string
+	TelemeterClientID string
+	TelemeterSecret   string
+}
+
+// BaselineOptions configures the comparison of the health signals with a previously promoted imagetag
+type BaselineOptions struct {
+	// ImageTag is the baseline imagetag, the comparison is skipped when it is empty
+	ImageTag string
+	// Offset is how far back the baseline window is, e.g. 24h
+	Offset    string
+	Tolerance float64
+	// SignalsFile optionally lists the signals to compare, see loadSignals
+	SignalsFile string
+}
+
+// CanaryOptions configures the canary analysis
+type CanaryOptions struct {
+	// MetricsFile optionally lists the metrics to compare, the baseline signals are used otherwise
+	MetricsFile   string
+	MinClusters   int
+	Alpha         float64
+	MarginalAlpha float64
+	PassScore     float64
+	MarginalScore float64
+}
+
+// Runner runs the workflows with its options. The connections opened by ocm.Connect and telemeter.Connect are
+// shared by every runner of the process, and runners used at once only get their own ocm and obsctl login when
+// their contexts carry different command environments, as the server does with helpers.WithCommandEnv.
+type Runner struct {
+	opts Options
+}
+
+// DefaultOptions returns the options with the defaults of the command line flags, the run specific fields are left empty
+func DefaultOptions() Options {
+	return Options{
+		SearchWindow:       "10m",
+		LivenessMetric:     "up",
+		InconclusivePolicy: InconclusivePolicyBlock,
+		OperatorKinds:      []string{"management", "service"},
+		ManagementChecks:   []string{CheckLiveness, CheckCSVSucceeded, CheckCSVAbnormal, CheckBaseline},
+		ServiceChecks:      []string{CheckLiveness, CheckCSVSucceeded, CheckCSVAbnormal, CheckBaseline},
+		MinClusters:        1,
+		CoveragePolicy:     CoveragePolicyFail,
+		Mode:               ModeAcceptance,
+		Baseline: BaselineOptions{
+			Offset:    "24h",
+			Tolerance: 0.1,
+		},
+		Canary: CanaryOptions{
+			MinClusters:   3,
+			Alpha:         0.05,
+			MarginalAlpha: 0.1,
+			PassScore:     95,
+			MarginalScore: 75,
+		},
+	}
+}
+
+// NewRunner returns a runner for the given options
+func NewRunner(opts Options) *Runner {
+	return &Runner{opts: opts}
+}
+
+// Options returns the options of the runner
+func (r *Runner) Options() Options {
+	return r.opts
+}
+
+// Run runs a whole acceptance run: the setup, the acceptance test or the canary analysis depending on the mode,
+// and the cleanup. It returns the report of the run and its errors, the verdict of the report is derived from them.
+// The notifier stored in ctx is told about the start and the completion of the run, and the metrics stored in ctx
+// record the duration of the run and of its phases and the verdicts of the clusters.
+func (r *Runner) Run(ctx context.Context) (*report.Report, []error) {
+	var errs []error
+	var err error
+
+	runReport := &report.Report{
+		Operator:    r.opts.Operator,
+		ImageTag:    r.opts.ImageTag,
+		Environment: r.opts.Environment,
+		Selectors:   r.opts.Selectors,
+		Mode:        r.opts.Mode,
+		StartTime:   time.Now(),
+	}
+	recorder := &retry.Recorder{}
+	logger := logging.FromContext(ctx)
+	notifier := notify.From(ctx)
+	runMetrics := metrics.From(ctx)
+
+	runCtx, span := tracing.Start(retry.WithRecorder(ctx, recorder), "Run",
+		attribute.String("operator", r.opts.Operator),
+		attribute.String("imagetag", r.opts.ImageTag),
+		attribute.String("environment", r.opts.Environment),
+		attribute.StringSlice("selectors", r.opts.Selectors),
+		attribute.String("mode", r.opts.Mode))
+	defer span.End()
+	notifier.Start(runCtx, runReport)
+
+	start := time.Now()
+	err = r.SetUp(runCtx)
+	runMetrics.ObservePhase("setup", time.Since(start))
+	if err != nil {
+		logger.Error("setup failed", "phase", "setup", "error", err)
+		errs = append(errs, err)
+		notifier.Fail(runCtx, notify.Failure{Reason: err.Error()})
+	}
+
+	start = time.Now()
+	if runReport.Mode == ModeCanary {
+		phaseCtx, phaseSpan := tracing.Start(runCtx, "CanaryAnalysis")
+		runReport.Canary, err = r.CanaryAnalysis(phaseCtx)
+		tracing.End(phaseSpan, err)
+		runMetrics.ObservePhase("canary", time.Since(start))
+		if err != nil {
+			logger.Error("canary analysis failed", "phase", "canary", "error", err)
+			errs = append(errs, err)
+		}
+	} else {
+		phaseCtx, phaseSpan := tracing.Start(runCtx, "AcceptanceTest")
+		runReport.Kinds, runReport.Coverage, err = r.AcceptanceTest(phaseCtx)
+		tracing.End(phaseSpan, err)
+		runMetrics.ObservePhase("acceptance", time.Since(start))
+		if err != nil {
+			logger.Error("acceptance test failed", "phase", "acceptance", "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	// The cleanup must still run after the run context expired
+	cleanUpCtx, cancel := context.WithTimeout(detachedContext{runCtx}, cleanUpTimeout)
+	defer cancel()
+
+	start = time.Now()
+	err = r.CleanUp(cleanUpCtx)
+	runMetrics.ObservePhase("cleanup", time.Since(start))
+	if err != nil {
+		logger.Error("cleanup failed", "phase", "cleanup", "error", err)
+		errs = append(errs, err)
+	}
+
+	runReport.Verdict = Verdict(errs)
+	runReport.EndTime = time.Now()
+	runReport.AddErrors(errs...)
+	runReport.Retries = recorder.Attempts()
+
+	for _, kind := range runReport.Kinds {
+		for _, cluster := range kind.Clusters {
+			runMetrics.ObserveCluster(cluster.Kind, cluster.Verdict, failureReason(cluster), cluster.Verdict == VerdictPassed)
+		}
+	}
+	runMetrics.ObserveRun(runReport.Environment, runReport.Mode, runReport.Verdict, runReport.EndTime.Sub(runReport.StartTime))
+
+	span.SetAttributes(attribute.String("verdict", runReport.Verdict))
+	if runReport.Verdict != VerdictPassed {
+		span.SetStatus(codes.Error, runReport.Verdict)
+	}
+
+	notifier.Complete(cleanUpCtx, runReport)
+
+	return runReport, errs
+}
+
+// failureReason names what kept the cluster from passing: the first check which did not pass,
+// the baseline comparison when every check passed, or the missing external ID when no check ran
+func failureReason(cluster report.ClusterResult) string {
+	for _, check := range cluster.Checks {
+		if !check.Passed {
+			return check.Name
+		}
+	}
+	if cluster.ExternalID == "" {
+		return "no_external_id"
+	}
+
+	return CheckBaseline
+}
+
+// detachedContext keeps the values of its parent, the timeouts, retry policy and logger of the run,
+// but is never canceled with it
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -1,77 +1,125 @@
 package workflows
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
 	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
 	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
-	"github.com/spf13/viper"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	"github.com/prometheus/common/model"
 )
 
-func SetUp(ocmToken, environment string) error {
+// SetUp checks the options of the runner and logs in to OCM and Telemeter, unless the run is replayed
+func (r *Runner) SetUp(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "SetUp")
+	err := r.setUp(ctx)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *Runner) setUp(ctx context.Context) error {
 	var errs []error
 	var err error
 
-	if !ocm.CliCheck() {
-		errs = append(errs, fmt.Errorf("ocm-cli is not installed"))
+	// A replayed run never talks to OCM or Telemeter, it only needs the configuration of the recorded run
+	if replay.Replaying(ctx) {
+		logging.FromContext(ctx).Info("replaying a recorded run, skipping the ocm and Telemeter logins", "phase", "setup")
+		err = r.validateRequiredVars(false)
+		if err != nil {
+			return fmt.Errorf("Acceptance Test setup failed: %w", classify(ErrConfiguration, err))
+		}
+
+		return nil
+	}
+
+	if !ocm.CliCheck(ctx) {
+		errs = append(errs, classify(ErrConfiguration, fmt.Errorf("ocm-cli is not installed")))
 	}
 
-	if !telemeter.CliCheck() {
-		errs = append(errs, fmt.Errorf("obsctl is not installed"))
+	if !telemeter.CliCheck(ctx) {
+		errs = append(errs, classify(ErrConfiguration, fmt.Errorf("obsctl is not installed")))
 	}
 
-	err = validateRequiredVars()
+	err = r.validateRequiredVars(true)
 	if err != nil {
-		errs = append(errs, err)
+		errs = append(errs, classify(ErrConfiguration, err))
 	}
 
-	err = ocm.Login(ocmToken, environment)
+	err = ocm.Login(ctx, r.opts.Credentials.OCMToken, r.opts.Environment, r.opts.OCMURL)
 	if err != nil {
-		errs = append(errs, err)
+		errs = append(errs, classifyBackendError(err))
 	}
 
 	// TODO: Update how I'm handling the telemeter config to be pointer based
-	telemeterConfig := telemeter.SetObsctlConfig(viper.GetString("environment"))
-	err = telemeter.ObsctlLogin(telemeterConfig)
+	telemeterConfig := telemeter.SetObsctlConfig(r.opts.Environment)
+	err = telemeter.ObsctlLogin(ctx, telemeterConfig, r.opts.Credentials.TelemeterClientID, r.opts.Credentials.TelemeterSecret)
 	if err != nil {
-		errs = append(errs, err)
+		errs = append(errs, classifyBackendError(err))
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("Acceptance Test setup failed: %v", errs)
+		return fmt.Errorf("Acceptance Test setup failed: %w", errors.Join(errs...))
 	}
 
 	return nil
 }
 
-func validateRequiredVars() error {
+// validateRequiredVars checks the configuration of the run, and the OCM and Telemeter credentials when credentials is set
+func (r *Runner) validateRequiredVars(credentials bool) error {
 	var errs []error
 
-	if len(viper.GetString("token")) == 0 {
+	if credentials && len(r.opts.Credentials.OCMToken) == 0 {
 		errs = append(errs, fmt.Errorf("ocm token is required"))
 	}
 
-	if len(viper.GetString("environment")) == 0 {
+	if len(r.opts.Environment) == 0 {
 		errs = append(errs, fmt.Errorf("environment is required"))
 	}
 
-	if len(viper.GetStringSlice("selectors")) == 0 {
+	if len(r.opts.Selectors) == 0 {
 		errs = append(errs, fmt.Errorf("selectors are required"))
 	}
 
-	if len(viper.GetString("operator")) == 0 {
+	if len(r.opts.Operator) == 0 {
 		errs = append(errs, fmt.Errorf("operator is required"))
 	}
 
-	if len(viper.GetString("imagetag")) == 0 {
+	if len(r.opts.ImageTag) == 0 {
 		errs = append(errs, fmt.Errorf("imagetag is required"))
 	}
 
-	if len(viper.GetString("TELEMETER_CLIENT_ID")) == 0 {
+	if policy := r.opts.CoveragePolicy; policy != CoveragePolicyFail && policy != CoveragePolicyWarn {
+		errs = append(errs, fmt.Errorf("coverage-policy must be %s or %s", CoveragePolicyFail, CoveragePolicyWarn))
+	}
+
+	errs = append(errs, r.validateKinds()...)
+
+	// The offset is pasted into the baseline queries, Observatorium would reject an invalid one as a bad request
+	if r.opts.Baseline.ImageTag != "" {
+		_, err := model.ParseDuration(r.opts.Baseline.Offset)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("baseline-offset must be a duration such as 24h: %v", err))
+		}
+	}
+
+	if mode := r.opts.Mode; mode != ModeAcceptance && mode != ModeCanary {
+		errs = append(errs, fmt.Errorf("mode must be %s or %s", ModeAcceptance, ModeCanary))
+	}
+
+	if policy := r.opts.InconclusivePolicy; policy != InconclusivePolicyBlock && policy != InconclusivePolicyAllow {
+		errs = append(errs, fmt.Errorf("inconclusive-policy must be %s or %s", InconclusivePolicyBlock, InconclusivePolicyAllow))
+	}
+
+	if credentials && len(r.opts.Credentials.TelemeterClientID) == 0 {
 		errs = append(errs, fmt.Errorf("TELEMETER_CLIENT_ID env is required"))
 	}
 
-	if len(viper.GetString("TELEMETER_SECRET")) == 0 {
+	if credentials && len(r.opts.Credentials.TelemeterSecret) == 0 {
 		errs = append(errs, fmt.Errorf("TELEMETER_SECRET env is required"))
 	}
 
@@ -81,3 +129,13 @@ func validateRequiredVars() error {
 
 	return nil
 }
+
+// classifyBackendError classifies an error returned by the ocm or telemeter packages.
+// Invalid configuration is reported as such, anything else means OCM or Telemeter let us down.
+func classifyBackendError(err error) error {
+	if errors.Is(err, ocm.ErrInvalidConfig) || errors.Is(err, telemeter.ErrInvalidConfig) {
+		return classify(ErrConfiguration, err)
+	}
+
+	return classify(ErrInfrastructure, err)
+}
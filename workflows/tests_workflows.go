@@ -1,60 +1,288 @@
 package workflows
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"time"
 
+	"github.com/MrSantamaria/acceptance_test/pkg/logging"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
 	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
 	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
-	"github.com/spf13/viper"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// InconclusivePolicyBlock makes clusters without telemetry fail the promotion as INCONCLUSIVE
+	InconclusivePolicyBlock = "block"
+	// InconclusivePolicyAllow only reports clusters without telemetry
+	InconclusivePolicyAllow = "allow"
 )
 
 /*
 1. We will gather a list of clusters that match the clusterDeploymentSelectors - Done
 2. We will grab the list of clusterIDs to verify with Telemeter on the csv_succeeded and csv_abnormal
-3. We will return a pass/fail depending on csv_succeeded > 0 and csv_abnormal == 0
+3. We will skip the clusters which did not report any telemetry in the search window, they are INCONCLUSIVE
+4. We will return a pass/fail depending on csv_succeeded > 0 and csv_abnormal == 0
+5. We will fail when too few of the selected clusters could be verified
 */
-func AcceptanceTest() error {
+func (r *Runner) AcceptanceTest(ctx context.Context) ([]report.KindResult, *report.Coverage, error) {
 	var err error
-	testStatus := "PASSED"
+	var results []report.ClusterResult
+	var errs []error
+	logger := logging.FromContext(ctx).With("phase", "acceptance")
+
+	discoveryCtx, span := tracing.Start(ctx, "ResolveClusters", attribute.StringSlice("selectors", r.opts.Selectors))
+	clusters, err := r.resolveClusters(discoveryCtx, logger)
+	span.SetAttributes(attribute.Int("clusters", len(clusters)))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	clusterIDs, err := ocm.GetManagementAndServiceClusterIDs()
+	if len(clusters) == 0 {
+		return nil, &report.Coverage{}, classify(ErrAcceptance, fmt.Errorf("selectors %v did not match any cluster", r.opts.Selectors))
+	}
+
+	signals, err := r.baselineSignals()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	for _, cluster := range clusters {
+		clusterLogger := logger.With("cluster_id", cluster.ClusterID, "kind", cluster.Kind, "external_id", cluster.ExternalID)
+		clusterCtx, span := tracing.Start(ctx, "CheckCluster",
+			attribute.String("cluster.id", cluster.ClusterID),
+			attribute.String("cluster.kind", cluster.Kind),
+			attribute.String("cluster.external_id", cluster.ExternalID))
+		result, err := r.checkCluster(clusterCtx, clusterLogger, cluster, signals)
+		span.SetAttributes(attribute.String("verdict", result.Verdict))
+		tracing.End(span, err)
+		results = append(results, result)
+		if err != nil {
+			errs = append(errs, err)
+			notify.Fail(ctx, notify.Failure{
+				ClusterID:  cluster.ClusterID,
+				Kind:       cluster.Kind,
+				ExternalID: cluster.ExternalID,
+				Verdict:    result.Verdict,
+				Reason:     err.Error(),
+			})
+		}
 	}
 
-	clusterExternalIDs, err := ocm.GetExternalIdFromClusterId(clusterIDs)
+	coverage, err := r.evaluateCoverage(logger, results)
 	if err != nil {
-		return err
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return r.groupByKind(results), coverage, errors.Join(errs...)
 	}
-	fmt.Printf("Cluster External IDs: %+v\n", clusterExternalIDs)
 
-	for _, clusterID := range clusterExternalIDs {
+	logger.Info("Acceptance Test "+VerdictPassed,
+		"verdict", VerdictPassed,
+		"operator", r.opts.Operator,
+		"imagetag", r.opts.ImageTag,
+		"selectors", r.opts.Selectors,
+		"verified", coverage.Verified)
+
+	return r.groupByKind(results), coverage, nil
+}
+
+// resolveClusters returns the clusters matching the selectors, of the kinds the operator is expected on,
+// along with their external IDs
+func (r *Runner) resolveClusters(ctx context.Context, logger *slog.Logger) ([]ocm.FleetCluster, error) {
+	var clusters []ocm.FleetCluster
 
-		searchResults, err := telemeter.ObsctlSearchQuery("csv_succeeded{_id=\"" + clusterID + "\", name=~\"" + viper.GetString("operator") + ".*" + viper.GetString("imagetag") + "\"}[" + viper.GetString("telemeterSearchTime") + "]")
+	managementClusters, serviceClusters, err := ocm.GetFleet(ctx)
+	if err != nil {
+		return nil, classifyBackendError(err)
+	}
+
+	selected, err := ocm.SelectClusters(ctx, managementClusters, serviceClusters, r.opts.Selectors, r.opts.Sectors)
+	if err != nil {
+		return nil, classifyBackendError(err)
+	}
+
+	kinds := r.expectedKinds()
+	for _, cluster := range selected {
+		if !kinds[cluster.Kind] {
+			logger.Debug("operator is not expected on this kind of cluster, skipping it", "cluster_id", cluster.ClusterID, "kind", cluster.Kind)
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	clusterIDs := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		clusterIDs = append(clusterIDs, cluster.ClusterID)
+	}
+
+	clusterExternalIDs, err := ocm.GetExternalIdFromClusterId(ctx, clusterIDs)
+	if err != nil {
+		return nil, classifyBackendError(err)
+	}
+
+	for i := range clusters {
+		clusters[i].ExternalID = clusterExternalIDs[clusters[i].ClusterID]
+	}
+	logger.Info("resolved clusters", "cluster_ids", clusterIDs, "external_ids", clusterExternalIDs)
+
+	if r.opts.InventoryCache != "" && !replay.Replaying(ctx) {
+		inventory := ocm.Inventory{
+			Environment:        r.opts.Environment,
+			Time:               time.Now().UTC(),
+			ManagementClusters: managementClusters,
+			ServiceClusters:    serviceClusters,
+			ExternalIDs:        clusterExternalIDs,
+		}
+		// External IDs resolved by earlier runs with other selectors are kept
+		if cached, err := ocm.LoadInventory(r.inventoryCachePath()); err == nil {
+			for clusterID, externalID := range cached.ExternalIDs {
+				if _, ok := inventory.ExternalIDs[clusterID]; !ok {
+					inventory.ExternalIDs[clusterID] = externalID
+				}
+			}
+		}
+		err = inventory.Write(r.inventoryCachePath())
 		if err != nil {
-			return err
+			logger.Warn("failed to cache the fleet inventory", "error", err)
 		}
-		if telemeter.ObsctlProccessSearchResult(searchResults) < 1 {
-			testStatus = "FAILED"
-			return fmt.Errorf("csv_succeeded count is 0")
+	}
+
+	return clusters, nil
+}
+
+// inventoryCachePath returns the path the fleet inventory of the environment is cached at
+func (r *Runner) inventoryCachePath() string {
+	return filepath.Join(r.opts.InventoryCache, "inventory-"+r.opts.Environment+".json")
+}
+
+// checkCluster verifies the operator CSV on a single cluster once it confirmed the cluster reports to Telemeter,
+// then compares the baseline signals when there is a baseline imagetag
+func (r *Runner) checkCluster(ctx context.Context, logger *slog.Logger, cluster ocm.FleetCluster, signals []Signal) (report.ClusterResult, error) {
+	clusterID := cluster.ExternalID
+	result := report.ClusterResult{ClusterID: cluster.ClusterID, Kind: cluster.Kind, ExternalID: clusterID, Verdict: VerdictPassed}
+	checks := r.checksForKind(cluster.Kind)
+
+	if clusterID == "" {
+		return result, r.inconclusive(logger, &result, fmt.Errorf("cluster %s has no external ID", cluster.ClusterID))
+	}
+
+	if checks[CheckLiveness] {
+		liveness, err := r.runCheck(ctx, CheckLiveness, r.livenessQuery(clusterID), func(series int) bool { return series > 0 })
+		result.Checks = append(result.Checks, liveness)
+		if err != nil {
+			result.Verdict = VerdictErrored
+			return result, err
 		}
+		if !liveness.Passed {
+			return result, r.inconclusive(logger, &result, fmt.Errorf("cluster %s has no telemetry data", clusterID))
+		}
+	}
 
-		searchResults, err = telemeter.ObsctlSearchQuery("csv_abnormal{_id=\"" + clusterID + "\", name=~\"" + viper.GetString("operator") + ".*" + viper.GetString("imagetag") + "\"}[" + viper.GetString("telemeterSearchTime") + "]")
+	if checks[CheckCSVSucceeded] {
+		succeeded, err := r.runCheck(ctx, CheckCSVSucceeded, r.csvQuery("csv_succeeded", clusterID), func(series int) bool { return series > 0 })
+		result.Checks = append(result.Checks, succeeded)
 		if err != nil {
-			return err
+			result.Verdict = VerdictErrored
+			return result, err
 		}
-		if telemeter.ObsctlProccessSearchResult(searchResults) > 0 {
-			testStatus = "FAILED"
-			return fmt.Errorf("csv_abnormal count is greater than 0")
+		if !succeeded.Passed {
+			result.Verdict = VerdictFailed
+			logger.Error("csv_succeeded check failed", "verdict", result.Verdict)
+			return result, classify(ErrAcceptance, fmt.Errorf("csv_succeeded count is 0 for cluster %s", clusterID))
 		}
 	}
 
-	fmt.Printf("Acceptance Test %s for: %s %s environment: %s selectors: %v\n",
-		testStatus,
-		viper.GetString("operator"),
-		viper.GetString("imagetag"),
-		viper.GetString("environment"),
-		viper.GetStringSlice("selectors"))
+	if checks[CheckCSVAbnormal] {
+		abnormal, err := r.runCheck(ctx, CheckCSVAbnormal, r.csvQuery("csv_abnormal", clusterID), func(series int) bool { return series == 0 })
+		result.Checks = append(result.Checks, abnormal)
+		if err != nil {
+			result.Verdict = VerdictErrored
+			return result, err
+		}
+		if !abnormal.Passed {
+			result.Verdict = VerdictFailed
+			logger.Error("csv_abnormal check failed", "verdict", result.Verdict)
+			return result, classify(ErrAcceptance, fmt.Errorf("csv_abnormal count is greater than 0 for cluster %s", clusterID))
+		}
+	}
+
+	if checks[CheckBaseline] && r.opts.Baseline.ImageTag != "" {
+		comparisons, err := r.compareWithBaseline(ctx, logger, clusterID, signals)
+		result.Comparisons = comparisons
+		if err != nil {
+			result.Verdict = VerdictErrored
+			return result, err
+		}
+		for _, comparison := range result.Comparisons {
+			if comparison.Regressed {
+				result.Verdict = VerdictFailed
+				return result, classify(ErrAcceptance, fmt.Errorf("%s regressed from %v to %v compared to %s on cluster %s",
+					comparison.Signal, comparison.Baseline, comparison.Current, r.opts.Baseline.ImageTag, clusterID))
+			}
+		}
+	}
+
+	logger.Info("cluster checks passed")
+
+	return result, nil
+}
+
+// inconclusive marks the result as INCONCLUSIVE and returns the reason as an error unless the inconclusive policy allows it
+func (r *Runner) inconclusive(logger *slog.Logger, result *report.ClusterResult, reason error) error {
+	result.Verdict = VerdictInconclusive
+	if r.opts.InconclusivePolicy == InconclusivePolicyAllow {
+		logger.Warn("cluster could not be verified, ignoring it", "verdict", result.Verdict, "reason", reason)
+		return nil
+	}
+
+	logger.Error("cluster could not be verified", "verdict", result.Verdict, "reason", reason)
+	return classify(ErrInconclusive, reason)
+}
+
+// runCheck runs query against Telemeter and evaluates the number of returned series with passed
+func (r *Runner) runCheck(ctx context.Context, name, query string, passed func(series int) bool) (report.CheckResult, error) {
+	check := report.CheckResult{Name: name, Query: query}
+
+	searchResults, err := telemeter.ObsctlSearchQuery(ctx, query)
+	if err != nil {
+		return check, classifyBackendError(err)
+	}
+
+	check.Series = telemeter.ObsctlSeriesCount(searchResults)
+	check.Passed = passed(check.Series)
+	if r.opts.Samples {
+		series, err := telemeter.ObsctlSeries(searchResults)
+		check.Samples = reportSeries(ctx, series, err)
+	}
+
+	return check, nil
+}
+
+// reportSeries returns the series returned by a query for the report, they are only kept to be plotted
+// so series which cannot be parsed are left out rather than failing the check
+func reportSeries(ctx context.Context, series []report.Series, err error) []report.Series {
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to parse the samples of a query", "error", err)
+		return nil
+	}
+
+	return series
+}
+
+func (r *Runner) livenessQuery(clusterID string) string {
+	return r.opts.LivenessMetric + "{_id=\"" + clusterID + "\"}[" + r.opts.SearchWindow + "]"
+}
 
-	return nil
+func (r *Runner) csvQuery(metric, clusterID string) string {
+	return metric + "{_id=\"" + clusterID + "\", name=~\"" + r.opts.Operator + ".*" + r.opts.ImageTag + "\"}[" + r.opts.SearchWindow + "]"
 }
@@ -0,0 +1,591 @@
+package workflows_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrSantamaria/acceptance_test/pkg/helpers"
+	"github.com/MrSantamaria/acceptance_test/pkg/metrics"
+	"github.com/MrSantamaria/acceptance_test/pkg/notify"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/ocm/ocmfake"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter"
+	"github.com/MrSantamaria/acceptance_test/pkg/openshift/telemeter/telemeterfake"
+	"github.com/MrSantamaria/acceptance_test/pkg/replay"
+	"github.com/MrSantamaria/acceptance_test/pkg/report"
+	"github.com/MrSantamaria/acceptance_test/pkg/retry"
+	"github.com/MrSantamaria/acceptance_test/workflows"
+)
+
+// setUpFakes points the ocm and telemeter packages at fake APIs, the Telemeter one serving the given fixtures,
+// and returns a runner checking the example operator on them
+func setUpFakes(t *testing.T, fixtures ...string) (*workflows.Runner, *telemeterfake.Server) {
+	t.Helper()
+
+	return setUpFleet(t, "", fixtures...)
+}
+
+// setUpFleet is setUpFakes with the fake OCM API serving the fleet in fleetDir, the embedded one when empty
+func setUpFleet(t *testing.T, fleetDir string, fixtures ...string) (*workflows.Runner, *telemeterfake.Server) {
+	t.Helper()
+	ctx := context.Background()
+
+	for i, fixture := range fixtures {
+		fixtures[i] = filepath.Join("testdata", "telemeter", fixture)
+	}
+
+	ocmServer, err := ocmfake.NewServer(ocmfake.Options{FixturesDir: fleetDir})
+	if err != nil {
+		t.Fatalf("failed to start the fake OCM API: %v", err)
+	}
+	t.Cleanup(ocmServer.Close)
+
+	telemeterServer, err := telemeterfake.NewServer(telemeterfake.Options{Fixtures: fixtures, ClientID: "client", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to start the fake Observatorium API: %v", err)
+	}
+	t.Cleanup(telemeterServer.Close)
+
+	err = ocm.Connect(ctx, ocmServer.URL, ocmfake.AccessToken())
+	if err != nil {
+		t.Fatalf("failed to connect to the fake OCM API: %v", err)
+	}
+	t.Cleanup(func() { ocm.Disconnect() })
+
+	token, err := telemeter.OidcToken(ctx, telemeterServer.URL, "observatorium-telemeter-staging", "client", "secret")
+	if err != nil {
+		t.Fatalf("failed to get a token from the fake OIDC provider: %v", err)
+	}
+	err = telemeter.Connect(ctx, telemeterServer.URL, "telemeter", token)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake Observatorium API: %v", err)
+	}
+	t.Cleanup(telemeter.Disconnect)
+
+	options := workflows.DefaultOptions()
+	options.Operator = "example-operator"
+	options.ImageTag = "abc123"
+	options.Environment = "stage"
+	options.Selectors = []string{"us-east-1", "main"}
+	options.ManagementChecks = []string{workflows.CheckLiveness, workflows.CheckCSVSucceeded, workflows.CheckCSVAbnormal}
+	options.ServiceChecks = []string{workflows.CheckLiveness, workflows.CheckCSVSucceeded, workflows.CheckCSVAbnormal}
+
+	return workflows.NewRunner(options), telemeterServer
+}
+
+func testContext() context.Context {
+	policy := retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1}
+
+	return retry.WithPolicy(context.Background(), policy)
+}
+
+func TestAcceptanceTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures []string
+		inject   int
+		exitCode int
+		verdicts map[string]string
+		verified int
+	}{
+		{
+			name:     "pass",
+			fixtures: []string{"management.json", "service.json"},
+			exitCode: workflows.ExitPassed,
+			verdicts: map[string]string{ocm.ManagementClusterKind: workflows.VerdictPassed, ocm.ServiceClusterKind: workflows.VerdictPassed},
+			verified: 2,
+		},
+		{
+			name:     "fail",
+			fixtures: []string{"management.json", "service.json", "abnormal.json"},
+			exitCode: workflows.ExitFailed,
+			verdicts: map[string]string{ocm.ManagementClusterKind: workflows.VerdictPassed, ocm.ServiceClusterKind: workflows.VerdictFailed},
+			verified: 2,
+		},
+		{
+			name:     "no data",
+			fixtures: []string{"management.json"},
+			exitCode: workflows.ExitInconclusive,
+			verdicts: map[string]string{ocm.ManagementClusterKind: workflows.VerdictPassed, ocm.ServiceClusterKind: workflows.VerdictInconclusive},
+			verified: 1,
+		},
+		{
+			name:     "error",
+			fixtures: []string{"management.json", "service.json"},
+			inject:   http.StatusServiceUnavailable,
+			exitCode: workflows.ExitInfrastructure,
+			verdicts: map[string]string{ocm.ManagementClusterKind: workflows.VerdictErrored, ocm.ServiceClusterKind: workflows.VerdictErrored},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, server := setUpFakes(t, tt.fixtures...)
+			if tt.inject != 0 {
+				server.InjectError("/api/metrics/v1/telemeter/api/v1/query", tt.inject, -1)
+			}
+
+			kinds, coverage, err := runner.AcceptanceTest(testContext())
+
+			var errs []error
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if exitCode := workflows.ExitCode(errs); exitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d, error: %v", exitCode, tt.exitCode, err)
+			}
+
+			verdicts := map[string]string{}
+			for _, kind := range kinds {
+				verdicts[kind.Kind] = kind.Verdict
+			}
+			for kind, verdict := range tt.verdicts {
+				if verdicts[kind] != verdict {
+					t.Errorf("%s verdict = %q, want %q", kind, verdicts[kind], verdict)
+				}
+			}
+
+			if coverage == nil || coverage.Selected != 2 || coverage.Verified != tt.verified {
+				t.Errorf("coverage = %+v, want 2 selected and %d verified", coverage, tt.verified)
+			}
+		})
+	}
+}
+
+func TestAcceptanceTestSamples(t *testing.T) {
+	for _, samples := range []bool{false, true} {
+		t.Run(fmt.Sprintf("samples %v", samples), func(t *testing.T) {
+			runner, _ := setUpFakes(t, "management.json", "service.json", "abnormal.json")
+			options := runner.Options()
+			options.Samples = samples
+			runner = workflows.NewRunner(options)
+
+			kinds, _, _ := runner.AcceptanceTest(testContext())
+
+			checks := 0
+			for _, kind := range kinds {
+				for _, cluster := range kind.Clusters {
+					for _, check := range cluster.Checks {
+						want := 0
+						if samples {
+							want = check.Series
+						}
+						if len(check.Samples) != want {
+							t.Errorf("%s on %s kept %d series of samples, want %d", check.Name, cluster.ClusterID, len(check.Samples), want)
+						}
+						checks++
+					}
+				}
+			}
+			if checks == 0 {
+				t.Fatal("no check was run")
+			}
+		})
+	}
+}
+
+func TestAcceptanceTestCoverage(t *testing.T) {
+	tests := []struct {
+		name               string
+		fixtures           []string
+		inconclusivePolicy string
+		minCoverage        float64
+		inject             int
+		exitCode           int
+		verified           int
+	}{
+		// The service cluster has no telemetry, which the inconclusive policy allows, but it leaves the coverage short
+		{
+			name:               "one silent cluster allowed",
+			fixtures:           []string{"management.json"},
+			inconclusivePolicy: workflows.InconclusivePolicyAllow,
+			minCoverage:        100,
+			exitCode:           workflows.ExitInconclusive,
+			verified:           1,
+		},
+		{
+			name:               "one silent cluster blocked",
+			fixtures:           []string{"management.json"},
+			inconclusivePolicy: workflows.InconclusivePolicyBlock,
+			minCoverage:        100,
+			exitCode:           workflows.ExitInconclusive,
+			verified:           1,
+		},
+		// No cluster has telemetry, the default minimum of one cluster is not reached
+		{
+			name:               "every cluster silent allowed",
+			inconclusivePolicy: workflows.InconclusivePolicyAllow,
+			exitCode:           workflows.ExitInconclusive,
+		},
+		{
+			name:               "every cluster silent blocked",
+			inconclusivePolicy: workflows.InconclusivePolicyBlock,
+			exitCode:           workflows.ExitInconclusive,
+		},
+		// A failed cluster is reported as such, the silent one does not hide it
+		{
+			name:               "failed and silent clusters",
+			fixtures:           []string{"service.json", "abnormal.json"},
+			inconclusivePolicy: workflows.InconclusivePolicyAllow,
+			minCoverage:        100,
+			exitCode:           workflows.ExitFailed,
+			verified:           1,
+		},
+		// The management cluster cannot be checked, the outage explains the shortfall and is reported instead
+		{
+			name:               "shortfall with an errored cluster",
+			fixtures:           []string{"management.json"},
+			inconclusivePolicy: workflows.InconclusivePolicyAllow,
+			minCoverage:        100,
+			inject:             2,
+			exitCode:           workflows.ExitInfrastructure,
+			verified:           0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, server := setUpFakes(t, tt.fixtures...)
+			options := runner.Options()
+			options.InconclusivePolicy = tt.inconclusivePolicy
+			options.MinCoverage = tt.minCoverage
+			runner = workflows.NewRunner(options)
+			if tt.inject != 0 {
+				server.InjectError("/api/metrics/v1/telemeter/api/v1/query", http.StatusServiceUnavailable, tt.inject)
+			}
+
+			_, coverage, err := runner.AcceptanceTest(testContext())
+			if exitCode := workflows.ExitCode([]error{err}); exitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d, error: %v", exitCode, tt.exitCode, err)
+			}
+			if coverage == nil || coverage.Selected != 2 || coverage.Verified != tt.verified {
+				t.Errorf("coverage = %+v, want 2 selected and %d verified", coverage, tt.verified)
+			}
+		})
+	}
+}
+
+func TestAcceptanceTestOperationTimeout(t *testing.T) {
+	runner, server := setUpFakes(t, "management.json", "service.json")
+	server.SetLatency(time.Second)
+
+	start := time.Now()
+	_, _, err := runner.AcceptanceTest(helpers.WithOperationTimeout(testContext(), 10*time.Millisecond))
+	if exitCode := workflows.ExitCode([]error{err}); exitCode != workflows.ExitTimeout {
+		t.Fatalf("exit code = %d, want %d, error: %v", exitCode, workflows.ExitTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("acceptance test took %v, want the queries to time out before the fake API answers", elapsed)
+	}
+}
+
+func TestAcceptanceTestOperatorKinds(t *testing.T) {
+	management := "2a1b3c4d5e6f7g8h9i0j1k2l3m4n5o6p"
+	service := "4c3d5e6f7g8h9i0j1k2l3m4n5o6p7q8r"
+
+	tests := []struct {
+		name     string
+		kinds    []string
+		clusters []string
+		byKind   map[string]report.KindCoverage
+	}{
+		{
+			name:     "management clusters only",
+			kinds:    []string{"management"},
+			clusters: []string{management},
+			byKind:   map[string]report.KindCoverage{ocm.ManagementClusterKind: {Selected: 1, Verified: 1}},
+		},
+		{
+			name:     "service clusters only",
+			kinds:    []string{"service"},
+			clusters: []string{service},
+			byKind:   map[string]report.KindCoverage{ocm.ServiceClusterKind: {Selected: 1, Verified: 1}},
+		},
+		{
+			name:     "both kinds",
+			kinds:    []string{"management", "service"},
+			clusters: []string{management, service},
+			byKind: map[string]report.KindCoverage{
+				ocm.ManagementClusterKind: {Selected: 1, Verified: 1},
+				ocm.ServiceClusterKind:    {Selected: 1, Verified: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := setUpFakes(t, "management.json", "service.json")
+			options := runner.Options()
+			options.OperatorKinds = tt.kinds
+			runner = workflows.NewRunner(options)
+
+			kinds, coverage, err := runner.AcceptanceTest(testContext())
+			if err != nil {
+				t.Fatalf("acceptance test failed: %v", err)
+			}
+
+			var clusters []string
+			for _, kind := range kinds {
+				for _, cluster := range kind.Clusters {
+					clusters = append(clusters, cluster.ClusterID)
+				}
+			}
+			if !reflect.DeepEqual(clusters, tt.clusters) {
+				t.Errorf("checked clusters = %v, want %v", clusters, tt.clusters)
+			}
+			if coverage.Selected != len(tt.clusters) || !reflect.DeepEqual(coverage.ByKind, tt.byKind) {
+				t.Errorf("coverage = %+v, want %d clusters selected, by kind %+v", coverage, len(tt.clusters), tt.byKind)
+			}
+		})
+	}
+}
+
+func TestAcceptanceTestReplay(t *testing.T) {
+	dir := t.TempDir()
+	runner, _ := setUpFakes(t, "management.json", "service.json", "abnormal.json")
+
+	recorder, err := replay.NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedKinds, _, recordedErr := runner.AcceptanceTest(replay.WithRecorder(testContext(), recorder))
+
+	// Nothing may reach the fake APIs once the run is replayed
+	ocm.Disconnect()
+	telemeter.Disconnect()
+
+	player, err := replay.NewPlayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayedKinds, _, replayedErr := runner.AcceptanceTest(replay.WithPlayer(testContext(), player))
+
+	if recordedErr == nil || replayedErr == nil || recordedErr.Error() != replayedErr.Error() {
+		t.Errorf("replayed error = %v, want %v", replayedErr, recordedErr)
+	}
+	if len(replayedKinds) != len(recordedKinds) {
+		t.Fatalf("replayed %d kinds, want %d", len(replayedKinds), len(recordedKinds))
+	}
+	for i := range recordedKinds {
+		if replayedKinds[i].Verdict != recordedKinds[i].Verdict {
+			t.Errorf("replayed %s verdict = %q, want %q", recordedKinds[i].Kind, replayedKinds[i].Verdict, recordedKinds[i].Verdict)
+		}
+	}
+}
+
+func TestAcceptanceTestNotifiesFirstFailure(t *testing.T) {
+	var events []notify.Event
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err != nil {
+			t.Errorf("failed to parse the webhook payload: %v", err)
+		}
+		events = append(events, event)
+	}))
+	t.Cleanup(webhook.Close)
+
+	notifier, err := notify.New([]notify.Sink{notify.NewWebhookSink(webhook.URL)}, notify.Templates{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner, _ := setUpFakes(t, "management.json", "service.json", "abnormal.json")
+	_, _, err = runner.AcceptanceTest(notify.WithNotifier(testContext(), notifier))
+	if err == nil {
+		t.Fatal("acceptance test passed, want the abnormal CSV to fail it")
+	}
+
+	if len(events) != 1 || events[0].Type != notify.EventFailed {
+		t.Fatalf("events = %+v, want the first failure only", events)
+	}
+	if failure := events[0].Failure; failure == nil || failure.Kind != ocm.ServiceClusterKind || failure.Verdict != workflows.VerdictFailed {
+		t.Errorf("failure = %+v, want the failed service cluster", failure)
+	}
+}
+
+func TestAcceptanceTestMetrics(t *testing.T) {
+	runMetrics := metrics.New()
+
+	runner, server := setUpFakes(t, "management.json", "service.json")
+	_, _, err := runner.AcceptanceTest(metrics.WithMetrics(testContext(), runMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	runMetrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	exposed := recorder.Body.String()
+
+	want := fmt.Sprintf(`acceptance_test_query_duration_seconds_count{backend="telemeter",outcome="success"} %d`, len(server.Queries()))
+	if !strings.Contains(exposed, want) || !strings.Contains(exposed, `backend="ocm",outcome="success"`) {
+		t.Errorf("metrics do not contain %s and the OCM calls:\n%s", want, exposed)
+	}
+}
+
+func TestAcceptanceTestBaseline(t *testing.T) {
+	signals := filepath.Join(t.TempDir(), "signals.json")
+	err := os.WriteFile(signals, []byte(`[{"name": "restarts", "query": "sum(increase(kube_pod_container_status_restarts_total{_id=\"{{.ClusterID}}\", namespace=~\".*{{.Operator}}.*\"}[{{.Window}}]{{.Offset}}))"}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		signalsFile string
+		comparisons int
+	}{
+		{name: "default signals", comparisons: 3},
+		{name: "signals file", signalsFile: signals, comparisons: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := setUpFakes(t, "management.json", "service.json", "restarts.json")
+			options := runner.Options()
+			options.ManagementChecks = append(options.ManagementChecks, workflows.CheckBaseline)
+			options.Baseline.ImageTag = "old456"
+			options.Baseline.SignalsFile = tt.signalsFile
+			runner = workflows.NewRunner(options)
+
+			kinds, _, err := runner.AcceptanceTest(testContext())
+			if exitCode := workflows.ExitCode([]error{err}); exitCode != workflows.ExitFailed {
+				t.Fatalf("exit code = %d, want %d, error: %v", exitCode, workflows.ExitFailed, err)
+			}
+
+			var management *report.ClusterResult
+			for _, kind := range kinds {
+				if kind.Kind == ocm.ManagementClusterKind && len(kind.Clusters) == 1 {
+					management = &kind.Clusters[0]
+				}
+			}
+			if management == nil || management.Verdict != workflows.VerdictFailed {
+				t.Fatalf("management cluster = %+v, want it to fail the baseline comparison", management)
+			}
+			if len(management.Comparisons) != tt.comparisons {
+				t.Fatalf("comparisons = %+v, want %d", management.Comparisons, tt.comparisons)
+			}
+
+			for _, comparison := range management.Comparisons {
+				if !strings.Contains(comparison.BaselineQuery, " offset 24h") || strings.Contains(comparison.CurrentQuery, "offset") {
+					t.Errorf("%s queries = %q and %q, want only the baseline one shifted back", comparison.Signal, comparison.BaselineQuery, comparison.CurrentQuery)
+				}
+				if comparison.Signal != "restarts" {
+					if comparison.Regressed {
+						t.Errorf("%s regressed from %v to %v, want no change", comparison.Signal, comparison.Baseline, comparison.Current)
+					}
+					continue
+				}
+				if !comparison.Regressed || comparison.Baseline != 0 || comparison.Current != 5 {
+					t.Errorf("restarts = %+v, want a regression from 0 to 5", comparison)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidBaselineOffset(t *testing.T) {
+	options := workflows.DefaultOptions()
+	options.Operator = "example-operator"
+	options.ImageTag = "abc123"
+	options.Environment = "stage"
+	options.Selectors = []string{"us-east-1", "main"}
+	options.Inventory = filepath.Join(t.TempDir(), "inventory.json")
+	options.Baseline.ImageTag = "old456"
+	options.Baseline.Offset = "1 day"
+
+	_, err := workflows.NewRunner(options).Plan(context.Background())
+	if workflows.ExitCode([]error{err}) != workflows.ExitConfiguration || !strings.Contains(fmt.Sprint(err), "baseline-offset") {
+		t.Errorf("error = %v, want the baseline offset to be rejected", err)
+	}
+}
+
+func TestKindsWithoutChecks(t *testing.T) {
+	tests := []struct {
+		name             string
+		managementChecks []string
+		serviceChecks    []string
+		baseline         string
+		valid            bool
+	}{
+		{name: "no checks", managementChecks: []string{}, serviceChecks: []string{workflows.CheckLiveness}},
+		{name: "baseline without imagetag", managementChecks: []string{workflows.CheckLiveness}, serviceChecks: []string{workflows.CheckBaseline}},
+		{name: "baseline with imagetag", managementChecks: []string{workflows.CheckLiveness}, serviceChecks: []string{workflows.CheckBaseline}, baseline: "old456", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := workflows.DefaultOptions()
+			options.Operator = "example-operator"
+			options.ImageTag = "abc123"
+			options.Environment = "stage"
+			options.Selectors = []string{"us-east-1", "main"}
+			options.Inventory = filepath.Join(t.TempDir(), "inventory.json")
+			options.ManagementChecks = tt.managementChecks
+			options.ServiceChecks = tt.serviceChecks
+			options.Baseline.ImageTag = tt.baseline
+
+			// The missing inventory is only looked for once the options are valid
+			_, err := workflows.NewRunner(options).Plan(context.Background())
+			rejected := strings.Contains(fmt.Sprint(err), "would not be checked")
+			if rejected == tt.valid {
+				t.Errorf("error = %v, want the kinds without checks to be rejected: %v", err, !tt.valid)
+			}
+		})
+	}
+}
+
+func TestPlanFromCachedInventory(t *testing.T) {
+	runner, server := setUpFakes(t, "management.json", "service.json")
+	options := runner.Options()
+	options.InventoryCache = t.TempDir()
+	runner = workflows.NewRunner(options)
+
+	_, _, err := runner.AcceptanceTest(testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := runner.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var planned []string
+	for _, cluster := range plan.Clusters {
+		if cluster.ExternalID == "" {
+			t.Errorf("cluster %s has no external ID in the cached inventory", cluster.ClusterID)
+		}
+		for _, query := range cluster.Queries {
+			planned = append(planned, query.Query)
+		}
+	}
+
+	sent := server.Queries()
+	if strings.Join(planned, "\n") != strings.Join(sent, "\n") {
+		t.Errorf("planned queries:\n%s\nwant the queries sent by the run:\n%s", strings.Join(planned, "\n"), strings.Join(sent, "\n"))
+	}
+}
+
+func TestPlanWithoutInventory(t *testing.T) {
+	options := workflows.DefaultOptions()
+	options.Operator = "example-operator"
+	options.ImageTag = "abc123"
+	options.Environment = "stage"
+	options.Selectors = []string{"us-east-1", "main"}
+	options.InventoryCache = t.TempDir()
+
+	_, err := workflows.NewRunner(options).Plan(context.Background())
+	if workflows.ExitCode([]error{err}) != workflows.ExitConfiguration {
+		t.Errorf("error = %v, want a configuration error", err)
+	}
+}
@@ -0,0 +1,113 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	VerdictPassed       = "PASSED"
+	VerdictFailed       = "FAILED"
+	VerdictErrored      = "ERRORED"
+	VerdictInconclusive = "INCONCLUSIVE"
+)
+
+// Exit codes returned by the acceptance_test binary
+const (
+	ExitPassed         = 0
+	ExitFailed         = 1
+	ExitConfiguration  = 2
+	ExitInfrastructure = 3
+	ExitTimeout        = 4
+	ExitInconclusive   = 5
+	ExitCanceled       = 6
+)
+
+// Error kinds wrapped around the errors returned by the workflows so callers can tell them apart with errors.Is
+var (
+	// ErrAcceptance means the operator did not pass the acceptance checks
+	ErrAcceptance = errors.New("acceptance check failed")
+	// ErrConfiguration means the run is misconfigured: missing flags, binaries or invalid selectors
+	ErrConfiguration = errors.New("configuration error")
+	// ErrInfrastructure means OCM or Telemeter could not be reached or refused our credentials
+	ErrInfrastructure = errors.New("infrastructure error")
+	// ErrInconclusive means there was not enough data to reach a verdict
+	ErrInconclusive = errors.New("inconclusive")
+)
+
+// exitCodePrecedence lists the error kinds from the most to the least significant.
+// A misconfigured run is reported first as nothing it checked can be trusted, then a real acceptance failure,
+// which must block the promotion even if other clusters could not be checked.
+var exitCodePrecedence = []struct {
+	matches  func(error) bool
+	exitCode int
+	verdict  string
+}{
+	{func(err error) bool { return errors.Is(err, ErrConfiguration) }, ExitConfiguration, VerdictErrored},
+	{func(err error) bool { return errors.Is(err, ErrAcceptance) }, ExitFailed, VerdictFailed},
+	{IsTimeout, ExitTimeout, VerdictErrored},
+	{IsCanceled, ExitCanceled, VerdictErrored},
+	{func(err error) bool { return errors.Is(err, ErrInfrastructure) }, ExitInfrastructure, VerdictErrored},
+	{func(err error) bool { return errors.Is(err, ErrInconclusive) }, ExitInconclusive, VerdictInconclusive},
+}
+
+// classify wraps err with the given error kind, nil errors stay nil
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// Classified reports whether err carries one of the error kinds returned by the workflows
+func Classified(err error) bool {
+	for _, kind := range exitCodePrecedence {
+		if kind.matches(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExitCode returns the exit code for the errors collected during a run.
+// Errors which were not classified are treated as acceptance failures.
+func ExitCode(errs []error) int {
+	exitCode, _ := outcome(errs)
+	return exitCode
+}
+
+// Verdict returns the overall verdict for the errors collected during a run.
+// Only acceptance failures are FAILED, a run which could not reach a conclusion is ERRORED or INCONCLUSIVE.
+func Verdict(errs []error) string {
+	_, verdict := outcome(errs)
+	return verdict
+}
+
+func outcome(errs []error) (int, string) {
+	if len(errs) == 0 {
+		return ExitPassed, VerdictPassed
+	}
+
+	for _, kind := range exitCodePrecedence {
+		for _, err := range errs {
+			if kind.matches(err) {
+				return kind.exitCode, kind.verdict
+			}
+		}
+	}
+
+	return ExitFailed, VerdictFailed
+}
+
+// IsTimeout reports whether err was caused by the run or an operation running out of time
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsCanceled reports whether err was caused by the run being cancelled, e.g. on SIGINT or SIGTERM
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
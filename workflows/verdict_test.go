@@ -0,0 +1,39 @@
+package workflows_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/MrSantamaria/acceptance_test/workflows"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		exitCode int
+		verdict  string
+	}{
+		{"passed", nil, workflows.ExitPassed, workflows.VerdictPassed},
+		{"timed out", []error{fmt.Errorf("obsctl metrics query: %w", context.DeadlineExceeded)}, workflows.ExitTimeout, workflows.VerdictErrored},
+		{"interrupted", []error{fmt.Errorf("obsctl metrics query: %w", context.Canceled)}, workflows.ExitCanceled, workflows.VerdictErrored},
+		{"failed before the interruption", []error{
+			fmt.Errorf("%w: csv_abnormal", workflows.ErrAcceptance),
+			fmt.Errorf("ocm get: %w", context.Canceled),
+		}, workflows.ExitFailed, workflows.VerdictFailed},
+		{"unclassified", []error{errors.New("boom")}, workflows.ExitFailed, workflows.VerdictFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if exitCode := workflows.ExitCode(tt.errs); exitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d", exitCode, tt.exitCode)
+			}
+			if verdict := workflows.Verdict(tt.errs); verdict != tt.verdict {
+				t.Errorf("verdict = %s, want %s", verdict, tt.verdict)
+			}
+		})
+	}
+}